@@ -0,0 +1,109 @@
+// Package bencode encodes and decodes bencoded data. Values are converted
+// by github.com/jackpal/bencode-go; RawDictValue and ValueEnd give access to
+// the exact encoding of a value, which info hashes are computed over.
+package bencode
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+
+	bencode "github.com/jackpal/bencode-go"
+)
+
+// Decode reads a bencoded value from r. Integers decode to int64, strings to
+// string, lists to []any and dictionaries to map[string]any.
+func Decode(r io.Reader) (any, error) {
+	return bencode.Decode(r)
+}
+
+// Unmarshal decodes a bencoded value from r into the struct, slice or map
+// pointed to by v, guided by bencode struct tags.
+func Unmarshal(r io.Reader, v any) error {
+	return bencode.Unmarshal(r, v)
+}
+
+// Marshal writes the bencoding of v to w. Dictionary keys are sorted.
+func Marshal(w io.Writer, v any) error {
+	return bencode.Marshal(w, v)
+}
+
+// RawDictValue returns the exact bencoded bytes of the value stored under key
+// in the top-level dictionary encoded in data.
+func RawDictValue(data []byte, key string) ([]byte, error) {
+	if len(data) == 0 || data[0] != 'd' {
+		return nil, fmt.Errorf("expect dictionary")
+	}
+
+	pos := 1
+	for pos < len(data) && data[pos] != 'e' {
+		keyStart := pos
+		keyEnd, err := ValueEnd(data, pos)
+		if err != nil {
+			return nil, fmt.Errorf("read key: %w", err)
+		}
+		if data[keyStart] < '0' || data[keyStart] > '9' {
+			return nil, fmt.Errorf("dictionary key is not a string")
+		}
+
+		valueEnd, err := ValueEnd(data, keyEnd)
+		if err != nil {
+			return nil, fmt.Errorf("read value: %w", err)
+		}
+
+		colon := bytes.IndexByte(data[keyStart:keyEnd], ':')
+		if string(data[keyStart+colon+1:keyEnd]) == key {
+			return data[keyEnd:valueEnd], nil
+		}
+		pos = valueEnd
+	}
+
+	return nil, fmt.Errorf("key %q not found", key)
+}
+
+// ValueEnd returns the position right after the bencoded value that
+// starts at pos.
+func ValueEnd(data []byte, pos int) (int, error) {
+	if pos >= len(data) {
+		return 0, fmt.Errorf("unexpected end of data")
+	}
+
+	switch c := data[pos]; {
+	case c == 'i':
+		end := bytes.IndexByte(data[pos:], 'e')
+		if end < 0 {
+			return 0, fmt.Errorf("unterminated integer")
+		}
+		return pos + end + 1, nil
+	case c == 'l' || c == 'd':
+		pos++
+		for pos < len(data) && data[pos] != 'e' {
+			next, err := ValueEnd(data, pos)
+			if err != nil {
+				return 0, err
+			}
+			pos = next
+		}
+		if pos >= len(data) {
+			return 0, fmt.Errorf("unterminated %c", c)
+		}
+		return pos + 1, nil
+	case c >= '0' && c <= '9':
+		colon := bytes.IndexByte(data[pos:], ':')
+		if colon < 0 {
+			return 0, fmt.Errorf("invalid string length")
+		}
+		length, err := strconv.Atoi(string(data[pos : pos+colon]))
+		if err != nil {
+			return 0, fmt.Errorf("invalid string length: %w", err)
+		}
+		end := pos + colon + 1 + length
+		if length < 0 || end > len(data) {
+			return 0, fmt.Errorf("string out of range")
+		}
+		return end, nil
+	default:
+		return 0, fmt.Errorf("unexpected byte %q at %d", c, pos)
+	}
+}
@@ -0,0 +1,413 @@
+// Package client downloads and seeds torrents. It finds peers through
+// trackers, the DHT and peer exchange and downloads pieces from peers and
+// web seeds in parallel.
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/dht"
+	"github.com/codecrafters-io/bittorrent-starter-go/metainfo"
+	"github.com/codecrafters-io/bittorrent-starter-go/storage"
+	"github.com/codecrafters-io/bittorrent-starter-go/tracker"
+)
+
+// DefaultPort is the port seeders listen on and the DHT binds when the config
+// doesn't name one.
+const DefaultPort = 6881
+
+// DefaultMaxPeers is the number of peers a download connects to at most when
+// the config leaves MaxPeers zero.
+const DefaultMaxPeers = 50
+
+// Bounds for the number of block requests kept outstanding per peer when
+// the config leaves them zero.
+const (
+	DefaultMinPipelineDepth = 5
+	DefaultMaxPipelineDepth = 250
+)
+
+// Timeouts used when the config leaves them zero.
+const (
+	DefaultConnectTimeout   = 10 * time.Second
+	DefaultHandshakeTimeout = 20 * time.Second
+	DefaultRequestTimeout   = time.Minute
+	DefaultIdleTimeout      = 3 * time.Minute
+)
+
+// Config configures a Client. The zero value is ready to use. Zero
+// timeouts take their default and negative ones disable the limit.
+type Config struct {
+	// PeerID identifies us in handshakes. A random one is used when it is
+	// nil.
+	PeerID []byte
+
+	// Port is the port seeders listen on and the DHT binds. Trackers and
+	// the DHT are only told about the port of a running seeder.
+	Port int
+
+	// NewStorage opens the storage a download to target is written to and
+	// is closed when the download stops. The files below target are used
+	// when it is nil.
+	NewStorage func(target string, torrentInfo *metainfo.TorrentInfo) (storage.Storage, error)
+
+	// MaxPeers bounds the peers a download is connected to or dialing at
+	// once. Further addresses wait until a connection closes.
+	MaxPeers int
+
+	// MinPipelineDepth and MaxPipelineDepth bound the block requests kept
+	// outstanding per peer. Within them the depth follows the rate of the
+	// peer.
+	MinPipelineDepth int
+	MaxPipelineDepth int
+
+	// ConnectTimeout bounds connecting to a peer and HandshakeTimeout the
+	// handshake that follows, including the metadata exchange of magnets.
+	ConnectTimeout   time.Duration
+	HandshakeTimeout time.Duration
+
+	// RequestTimeout is how long a peer or web seed may take to deliver a
+	// requested block before it is dropped and its piece goes to others.
+	RequestTimeout time.Duration
+
+	// IdleTimeout drops peers that send nothing, not even a keep-alive,
+	// for that long.
+	IdleTimeout time.Duration
+
+	// TrackerTimeout is how long each tracker gets to answer an announce,
+	// tracker.DefaultTimeout when zero.
+	TrackerTimeout time.Duration
+
+	// OnEvent, when set, is called with the events of every download. It
+	// runs on the goroutines of the download and should return quickly.
+	OnEvent func(Event)
+
+	// Logger receives the failures the client works around, such as peers
+	// that could not be reached. Nothing is logged when it is nil.
+	Logger *log.Logger
+}
+
+// timeout returns the configured timeout d: defaultTimeout when it is zero
+// and none, 0, when it is negative.
+func timeout(d, defaultTimeout time.Duration) time.Duration {
+	switch {
+	case d == 0:
+		return defaultTimeout
+	case d < 0:
+		return 0
+	}
+	return d
+}
+
+// withTimeout is context.WithTimeout, without a deadline for a zero timeout.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// Client downloads torrents in the background. A torrent can only be added
+// again once its previous download is done.
+type Client struct {
+	peerID     []byte
+	port       int
+	newStorage func(target string, torrentInfo *metainfo.TorrentInfo) (storage.Storage, error)
+	maxPeers   int
+	pipeline   pipeline
+
+	connectTimeout   time.Duration
+	handshakeTimeout time.Duration
+	requestTimeout   time.Duration
+	idleTimeout      time.Duration
+	trackerTimeout   time.Duration
+
+	onEvent func(Event)
+	logger  *log.Logger
+
+	// dhtNode is the DHT node of the client, bootstrapped on first use
+	dhtMu   sync.Mutex
+	dhtNode *dht.DHT
+
+	mu        sync.Mutex
+	downloads map[string]*Download
+	// listenPort is the port of the running seeder, 0 when none listens
+	listenPort int
+}
+
+// New returns a client configured by config.
+func New(config Config) *Client {
+	c := &Client{
+		peerID:     config.PeerID,
+		port:       config.Port,
+		newStorage: config.NewStorage,
+		maxPeers:   config.MaxPeers,
+		pipeline:   pipeline{min: config.MinPipelineDepth, max: config.MaxPipelineDepth},
+
+		connectTimeout:   timeout(config.ConnectTimeout, DefaultConnectTimeout),
+		handshakeTimeout: timeout(config.HandshakeTimeout, DefaultHandshakeTimeout),
+		requestTimeout:   timeout(config.RequestTimeout, DefaultRequestTimeout),
+		idleTimeout:      timeout(config.IdleTimeout, DefaultIdleTimeout),
+		trackerTimeout:   timeout(config.TrackerTimeout, tracker.DefaultTimeout),
+
+		onEvent:   config.OnEvent,
+		logger:    config.Logger,
+		downloads: map[string]*Download{},
+	}
+	if c.peerID == nil {
+		c.peerID = make([]byte, 20)
+		if _, err := rand.Read(c.peerID); err != nil {
+			panic(err)
+		}
+	}
+	if c.port == 0 {
+		c.port = DefaultPort
+	}
+	if c.newStorage == nil {
+		c.newStorage = storage.NewFile
+	}
+	if c.maxPeers <= 0 {
+		c.maxPeers = DefaultMaxPeers
+	}
+	if c.pipeline.min <= 0 {
+		c.pipeline.min = DefaultMinPipelineDepth
+	}
+	if c.pipeline.max <= 0 {
+		c.pipeline.max = DefaultMaxPipelineDepth
+	}
+	c.pipeline.max = max(c.pipeline.max, c.pipeline.min)
+	if c.logger == nil {
+		c.logger = log.New(io.Discard, "", 0)
+	}
+	return c
+}
+
+// dht returns the DHT node of the client, starting and bootstrapping it on
+// first use. A failed bootstrap, such as one cut short by ctx, is tried
+// again by the next call.
+func (c *Client) dht(ctx context.Context) (*dht.DHT, error) {
+	c.dhtMu.Lock()
+	defer c.dhtMu.Unlock()
+
+	if c.dhtNode != nil {
+		return c.dhtNode, nil
+	}
+
+	d, err := dht.New(":" + strconv.Itoa(c.port))
+	if err != nil {
+		d, err = dht.New(":0")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := d.Bootstrap(ctx, dht.BootstrapNodes); err != nil {
+		d.Close()
+		return nil, fmt.Errorf("bootstrap: %w", err)
+	}
+	c.dhtNode = d
+	return d, nil
+}
+
+// PeerID returns the peer id sent in handshakes.
+func (c *Client) PeerID() []byte {
+	return c.peerID
+}
+
+// Port returns the port seeders should listen on.
+func (c *Client) Port() int {
+	return c.port
+}
+
+// advertisedPort returns the port peers can connect to us on, or 0 while no
+// seeder listens. Announcing a port nobody listens on only wastes the time
+// of the peers trying it.
+func (c *Client) advertisedPort() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.listenPort
+}
+
+func (c *Client) setListenPort(old, port int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.listenPort == old {
+		c.listenPort = port
+	}
+}
+
+// AddTorrent starts downloading the torrent to target. The content already
+// in storage is checked first; only the missing pieces are downloaded.
+func (c *Client) AddTorrent(torrent *metainfo.Torrent, target string) (*Download, error) {
+	d, err := c.add(torrent.Info.Hash())
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := c.newStorage(target, &torrent.Info)
+	if err != nil {
+		d.cancel()
+		c.remove(d)
+		return nil, err
+	}
+	d.setStorage(&torrent.Info, store)
+
+	go d.run(func(ctx context.Context) error {
+		return c.downloadTorrent(ctx, d, torrent, store)
+	})
+	return d, nil
+}
+
+// AddMagnet starts downloading the torrent of the magnet to target. The
+// metadata is fetched from the first peer that has it.
+func (c *Client) AddMagnet(magnet *metainfo.Magnet, target string) (*Download, error) {
+	d, err := c.add(magnet.InfoHash)
+	if err != nil {
+		return nil, err
+	}
+
+	go d.run(func(ctx context.Context) error {
+		return c.downloadMagnet(ctx, d, magnet, target)
+	})
+	return d, nil
+}
+
+// add registers a download of infoHash, which runs until it is done.
+func (c *Client) add(infoHash []byte) (*Download, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.downloads[string(infoHash)]; ok {
+		return nil, fmt.Errorf("torrent %x already added", infoHash)
+	}
+	d := newDownload(infoHash, c.onEvent)
+	d.onDone = func() { c.remove(d) }
+	c.downloads[string(infoHash)] = d
+	return d, nil
+}
+
+func (c *Client) remove(d *Download) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.downloads[string(d.infoHash)] == d {
+		delete(c.downloads, string(d.infoHash))
+	}
+}
+
+// Close cancels every download, waits for them to stop and closes the DHT
+// node.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	var downloads []*Download
+	for _, d := range c.downloads {
+		downloads = append(downloads, d)
+	}
+	c.mu.Unlock()
+
+	for _, d := range downloads {
+		d.Cancel()
+	}
+	for _, d := range downloads {
+		<-d.Done()
+	}
+
+	c.dhtMu.Lock()
+	defer c.dhtMu.Unlock()
+	if c.dhtNode != nil {
+		return c.dhtNode.Close()
+	}
+	return nil
+}
+
+// trackerPeerID is the printable peer id announced to trackers.
+func (c *Client) trackerPeerID() []byte {
+	return []byte(hex.EncodeToString(c.peerID)[:20])
+}
+
+func (c *Client) newTiers(trackers [][]string) *tracker.Tiers {
+	tiers := tracker.NewTiers(trackers)
+	tiers.Timeout = c.trackerTimeout
+	tiers.Logger = c.logger
+	return tiers
+}
+
+// NewAnnouncer returns an announcer that reports the counters in stats for
+// the torrent with infoHash to its trackers.
+func (c *Client) NewAnnouncer(trackers [][]string, infoHash []byte, stats *tracker.Stats) *tracker.Announcer {
+	request := tracker.AnnounceRequest{InfoHash: infoHash, PeerID: c.trackerPeerID(), Port: c.advertisedPort()}
+	return tracker.NewAnnouncer(c.newTiers(trackers), request, stats)
+}
+
+// Peers announces to the trackers and falls back to the DHT when they fail
+// or know no peers. Private torrents never use the DHT.
+func (c *Client) Peers(ctx context.Context, trackers [][]string, infoHash []byte, left int, private bool) ([]string, error) {
+	request := tracker.AnnounceRequest{InfoHash: infoHash, PeerID: c.trackerPeerID(), Port: c.advertisedPort(), Left: left}
+	peers, _, err := c.newTiers(trackers).Announce(ctx, &request)
+	return c.dhtFallback(ctx, peers, err, infoHash, private)
+}
+
+// dhtFallback returns the peers the trackers answered with, or asks the DHT
+// when there are none. Private torrents never use the DHT.
+func (c *Client) dhtFallback(ctx context.Context, peers []string, err error, infoHash []byte, private bool) ([]string, error) {
+	if len(peers) > 0 || private || ctx.Err() != nil {
+		return peers, err
+	}
+
+	node, dhtErr := c.dht(ctx)
+	if dhtErr == nil {
+		peers, dhtErr = node.GetPeers(ctx, infoHash, c.advertisedPort())
+	}
+	if dhtErr != nil {
+		return nil, errors.Join(err, fmt.Errorf("dht: %w", dhtErr))
+	}
+	return peers, nil
+}
+
+// AnnounceDHT tells the DHT that we have the torrent with infoHash. It
+// needs a running seeder.
+func (c *Client) AnnounceDHT(ctx context.Context, infoHash []byte) error {
+	port := c.advertisedPort()
+	if port == 0 {
+		return fmt.Errorf("no seeder is listening")
+	}
+	node, err := c.dht(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = node.GetPeers(ctx, infoHash, port)
+	return err
+}
+
+// DialPeer connects to the peer at addr and performs the handshake for the
+// torrent with infoHash.
+func (c *Client) DialPeer(ctx context.Context, addr string, infoHash []byte) (*Peer, error) {
+	peer, _, err := c.dialPeer(ctx, addr, infoHash, false)
+	return peer, err
+}
+
+// DialMetadataPeer tries the peers in turn until one of them hands out the
+// metadata of the torrent with infoHash.
+func (c *Client) DialMetadataPeer(ctx context.Context, peers []string, infoHash []byte) (*Peer, *metainfo.TorrentInfo, error) {
+	return c.dialMetadataPeer(ctx, peers, infoHash)
+}
+
+// DownloadPiece downloads piece index of torrentInfo from the peer into
+// store.
+func (c *Client) DownloadPiece(ctx context.Context, peer *Peer, torrentInfo *metainfo.TorrentInfo, index int, store storage.Storage) error {
+	if index < 0 || index >= torrentInfo.PieceCount() {
+		return fmt.Errorf("piece %d out of range", index)
+	}
+	s := &swarm{infoHash: torrentInfo.Hash(), peers: []*Peer{peer}}
+	return c.downloadTasks(ctx, s, torrentInfo, []task{{pieceIndex: index}}, store)
+}
@@ -0,0 +1,42 @@
+package client
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/storage"
+)
+
+func TestDownloadPieceOutOfRange(t *testing.T) {
+	_, torrentInfo := webSeedTorrent("f.bin", 40000, 16384, nil)
+	c := New(Config{})
+	defer c.Close()
+
+	for _, index := range []int{-1, 3} {
+		err := c.DownloadPiece(context.Background(), nil, torrentInfo, index, storage.NewMemory(torrentInfo))
+		if err == nil || !strings.Contains(err.Error(), "out of range") {
+			t.Errorf("piece %d: got error %v, want it out of range", index, err)
+		}
+	}
+}
+
+func TestPipelineConfig(t *testing.T) {
+	tests := []struct {
+		config   Config
+		min, max int
+	}{
+		{Config{}, DefaultMinPipelineDepth, DefaultMaxPipelineDepth},
+		{Config{MinPipelineDepth: 2, MaxPipelineDepth: 8}, 2, 8},
+		{Config{MinPipelineDepth: 300}, 300, 300},
+	}
+	for _, test := range tests {
+		p := New(test.config).pipeline
+		if got := p.depth(0); got != test.min {
+			t.Errorf("%+v: depth of an idle peer %d, want %d", test.config, got, test.min)
+		}
+		if got := p.depth(1e12); got != test.max {
+			t.Errorf("%+v: depth of a fast peer %d, want %d", test.config, got, test.max)
+		}
+	}
+}
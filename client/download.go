@@ -0,0 +1,213 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/metainfo"
+	"github.com/codecrafters-io/bittorrent-starter-go/storage"
+	"github.com/codecrafters-io/bittorrent-starter-go/tracker"
+)
+
+// Download is a torrent being downloaded by a Client.
+type Download struct {
+	infoHash []byte
+	stats    tracker.Stats
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+
+	// onDone runs once the download stopped, before Done is closed
+	onDone func()
+
+	report *reporter
+
+	mu          sync.Mutex
+	torrentInfo *metainfo.TorrentInfo
+	store       storage.Storage
+}
+
+func newDownload(infoHash []byte, onEvent func(Event)) *Download {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Download{
+		infoHash: infoHash,
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	d.report = newReporter(infoHash, onEvent, d.Progress)
+	return d
+}
+
+// InfoHash returns the info hash the download was added with.
+func (d *Download) InfoHash() []byte {
+	return d.infoHash
+}
+
+// Info returns the info dictionary of the torrent, or nil while the
+// metadata of a magnet is still being fetched.
+func (d *Download) Info() *metainfo.TorrentInfo {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.torrentInfo
+}
+
+// Progress returns the current state of the download.
+func (d *Download) Progress() Progress {
+	d.mu.Lock()
+	torrentInfo, store := d.torrentInfo, d.store
+	d.mu.Unlock()
+
+	progress := Progress{
+		Downloaded: d.stats.Downloaded(),
+		Uploaded:   d.stats.Uploaded(),
+		Left:       d.stats.Left(),
+	}
+	if torrentInfo != nil {
+		progress.Pieces = store.Completed().Count()
+		progress.PieceCount = torrentInfo.PieceCount()
+	}
+	d.report.fill(&progress)
+	return progress
+}
+
+// Cancel stops the download. The content downloaded so far stays in
+// storage, so adding the torrent again resumes it.
+func (d *Download) Cancel() {
+	d.cancel()
+}
+
+// Done is closed when the download has finished, failed or was cancelled.
+func (d *Download) Done() <-chan struct{} {
+	return d.done
+}
+
+// Wait waits for the download to stop and returns why it did: nil once
+// every piece is stored, or context.Canceled after Cancel.
+func (d *Download) Wait() error {
+	<-d.done
+	return d.err
+}
+
+func (d *Download) setStorage(torrentInfo *metainfo.TorrentInfo, store storage.Storage) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.torrentInfo, d.store = torrentInfo, store
+}
+
+// run runs download until it returns and closes the storage behind it. The
+// download is only completed once the storage closed cleanly.
+func (d *Download) run(download func(ctx context.Context) error) {
+	defer close(d.done)
+	defer d.cancel()
+	if d.onDone != nil {
+		defer d.onDone()
+	}
+
+	d.err = download(d.ctx)
+
+	d.mu.Lock()
+	store := d.store
+	d.mu.Unlock()
+	if store != nil {
+		if err := store.Close(); err != nil && d.err == nil {
+			d.err = err
+		}
+	}
+	if d.err == nil {
+		d.report.completed()
+	}
+}
+
+func (c *Client) downloadTorrent(ctx context.Context, d *Download, torrent *metainfo.Torrent, store storage.Storage) error {
+	infoHash := torrent.Info.Hash()
+	// a resumed download only has the missing pieces left
+	d.stats.SetLeft(missingLength(&torrent.Info, store.Completed()))
+	announcer := c.NewAnnouncer(torrent.Trackers(), infoHash, &d.stats)
+	peers, err := announcer.Start(ctx)
+	defer announcer.Stop()
+	peers, err = c.dhtFallback(ctx, peers, err, infoHash, torrent.Info.Private == 1)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+
+	// web seeds can carry the download without any peers
+	if err != nil && len(torrent.URLList) == 0 {
+		return err
+	}
+	if err != nil {
+		c.logger.Printf("peers: %v", err)
+	}
+
+	if len(peers) == 0 && len(torrent.URLList) == 0 {
+		return fmt.Errorf("no peers")
+	}
+
+	// the peers are dialed by the swarm, concurrently
+	s := &swarm{
+		infoHash: infoHash,
+		addrs:    peers,
+		webSeeds: torrent.URLList,
+		more:     announcer.Peers(),
+		stats:    &d.stats,
+		report:   d.report,
+	}
+	if err := c.downloadAll(ctx, store, &torrent.Info, s); err != nil {
+		return err
+	}
+	// a download that was already complete has nothing to report
+	if d.stats.Downloaded() > 0 {
+		announcer.Completed()
+	}
+	return nil
+}
+
+func (c *Client) downloadMagnet(ctx context.Context, d *Download, magnet *metainfo.Magnet, target string) error {
+	// the size is unknown until the metadata arrives
+	d.stats.SetLeft(1)
+	announcer := c.NewAnnouncer(magnet.Trackers(), magnet.InfoHash, &d.stats)
+	peers, err := announcer.Start(ctx)
+	defer announcer.Stop()
+	peers, err = c.dhtFallback(ctx, peers, err, magnet.InfoHash, false)
+	if err != nil {
+		return err
+	}
+
+	// fetch the metadata once, from the first peer that serves it
+	peer, torrentInfo, err := c.dialMetadataPeer(ctx, peers, magnet.InfoHash)
+	if err != nil {
+		return err
+	}
+
+	defer peer.Close()
+
+	store, err := c.newStorage(target, torrentInfo)
+	if err != nil {
+		return err
+	}
+	d.setStorage(torrentInfo, store)
+
+	// the other peers only need a plain handshake, which the swarm does
+	// when it dials them; the metadata peer is already connected
+	s := &swarm{
+		infoHash: magnet.InfoHash,
+		peers:    []*Peer{peer},
+		addrs:    peers,
+		webSeeds: magnet.WebSeeds,
+		more:     announcer.Peers(),
+		stats:    &d.stats,
+		report:   d.report,
+	}
+	if err := c.downloadAll(ctx, store, torrentInfo, s); err != nil {
+		return err
+	}
+	if d.stats.Downloaded() > 0 {
+		announcer.Completed()
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"math/bits"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/metainfo"
+	"github.com/codecrafters-io/bittorrent-starter-go/peerwire"
+)
+
+// https://www.bittorrent.org/beps/bep_0009.html
+
+// utMetadataID is the extension message id we assign to ut_metadata in our
+// extension handshake. Peers tag the metadata messages they send us with it.
+const utMetadataID = 1
+
+// maxMetadataSize bounds the metadata_size a peer can make us allocate.
+const maxMetadataSize = 16 * 1024 * 1024 // 16MB
+
+// fetchMetadata exchanges extension handshakes with the peer and downloads
+// every piece of the info dictionary through ut_metadata. The assembled
+// metadata is only returned if its hash matches infoHash. Other messages
+// received in the meantime, such as the bitfield, update the session.
+func fetchMetadata(peer *Peer, infoHash []byte) (*metainfo.TorrentInfo, error) {
+	if len(infoHash) != sha1.Size && len(infoHash) != sha256.Size {
+		return nil, fmt.Errorf("invalid info hash length %d", len(infoHash))
+	}
+
+	extensionPayload := peerwire.ExtensionPayload{
+		MessageID: 0,
+		Message: map[string]any{
+			"m": map[string]any{
+				"ut_metadata": utMetadataID,
+			},
+		},
+	}
+	if err := sendExtension(peer, &extensionPayload); err != nil {
+		return nil, err
+	}
+
+	// wait for the peer's extension handshake
+	for peer.extensions == nil {
+		m, err := readExtension(peer)
+		if err != nil {
+			return nil, err
+		}
+		if err := peer.handleExtension(m); err != nil {
+			return nil, err
+		}
+	}
+	peerExtID, size := peer.extensions["ut_metadata"], peer.metadataSize
+	if peerExtID <= 0 || peerExtID > 255 {
+		return nil, fmt.Errorf("ut_metadata not supported")
+	}
+	if size <= 0 || size > maxMetadataSize {
+		return nil, fmt.Errorf("invalid metadata size %d", size)
+	}
+
+	// request all pieces up front, the metadata is small
+	pieceCount := (size + peerwire.MetadataPieceSize - 1) / peerwire.MetadataPieceSize
+	for piece := 0; piece < pieceCount; piece++ {
+		extensionPayload = peerwire.ExtensionPayload{
+			MessageID: byte(peerExtID),
+			Message: map[string]any{
+				"msg_type": peerwire.MetadataRequest,
+				"piece":    piece,
+			},
+		}
+		if err := sendExtension(peer, &extensionPayload); err != nil {
+			return nil, err
+		}
+	}
+
+	metadata := make([]byte, size)
+	received := make([]bool, pieceCount)
+	for remaining := pieceCount; remaining > 0; {
+		m, err := readExtension(peer)
+		if err != nil {
+			return nil, err
+		}
+		if m[0] != utMetadataID {
+			if err := peer.handleExtension(m); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		msgType, piece, data, err := peerwire.ParseMetadataMessage(m[1:])
+		if err != nil {
+			return nil, err
+		}
+		switch msgType {
+		case peerwire.MetadataReject:
+			return nil, fmt.Errorf("metadata piece %d rejected", piece)
+		case peerwire.MetadataData:
+			if piece < 0 || piece >= pieceCount {
+				return nil, fmt.Errorf("unexpected metadata piece %d", piece)
+			}
+			begin := piece * peerwire.MetadataPieceSize
+			if len(data) != min(peerwire.MetadataPieceSize, size-begin) {
+				return nil, fmt.Errorf("metadata piece %d has %d bytes", piece, len(data))
+			}
+			if !received[piece] {
+				copy(metadata[begin:], data)
+				received[piece] = true
+				remaining--
+			}
+		}
+	}
+
+	// v2 magnets carry the SHA-256 hash, truncated to 20 bytes or in full
+	if !metadataMatches(metadata, infoHash) {
+		return nil, fmt.Errorf("metadata hash mismatch")
+	}
+	torrentInfo, err := metainfo.NewTorrentInfo(metadata)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal metadata: %w", err)
+	}
+	return torrentInfo, nil
+}
+
+// metadataMatches reports whether metadata hashes to infoHash: the SHA-1
+// hash or the truncated SHA-256 hash for 20 bytes, the SHA-256 hash for 32.
+func metadataMatches(metadata, infoHash []byte) bool {
+	hashV2 := sha256.Sum256(metadata)
+	switch len(infoHash) {
+	case sha1.Size:
+		hash := sha1.Sum(metadata)
+		return bytes.Equal(hash[:], infoHash) || bytes.Equal(hashV2[:sha1.Size], infoHash)
+	case sha256.Size:
+		return bytes.Equal(hashV2[:], infoHash)
+	}
+	return false
+}
+
+// maxHashesPerRequest is the most hashes we ask for in a single hash request.
+const maxHashesPerRequest = 512
+
+// fetchPieceLayers requests the piece layers missing from the metadata of a
+// v2 torrent from the peer. Every chunk of hashes is checked against the
+// pieces root of its file with the uncle hashes sent along.
+func fetchPieceLayers(peer *Peer, torrentInfo *metainfo.TorrentInfo) error {
+	missing := torrentInfo.MissingPieceLayers()
+	if len(missing) == 0 {
+		return nil
+	}
+
+	baseLayer := bits.TrailingZeros(uint(torrentInfo.PieceLength / metainfo.MerkleBlockSize))
+	for _, file := range missing {
+		count := (file.Length + torrentInfo.PieceLength - 1) / torrentInfo.PieceLength
+		width := 1 << bits.Len(uint(count-1))
+		length := min(width, maxHashesPerRequest)
+		proofLayers := bits.TrailingZeros(uint(width / length))
+
+		var layer []byte
+		for index := 0; index < count; index += length {
+			request := peerwire.HashRequestPayload{
+				PiecesRoot:  []byte(file.PiecesRoot),
+				BaseLayer:   uint32(baseLayer),
+				Index:       uint32(index),
+				Length:      uint32(length),
+				ProofLayers: uint32(proofLayers),
+			}
+			payload, err := request.MarshalBinary()
+			if err != nil {
+				return fmt.Errorf("marshal hash request: %w", err)
+			}
+			if err := peer.send(&peerwire.PeerMessage{ID: peerwire.IDHashRequest, Payload: payload}); err != nil {
+				return fmt.Errorf("send hash request: %w", err)
+			}
+
+			hashes, err := readHashes(peer, &request)
+			if err != nil {
+				return err
+			}
+			if len(hashes) != (length+proofLayers)*sha256.Size {
+				return fmt.Errorf("hashes for %x: got %d bytes", file.PiecesRoot, len(hashes))
+			}
+			chunk, err := torrentInfo.VerifyHashes(file, index, length, hashes)
+			if err != nil {
+				return err
+			}
+			layer = append(layer, chunk...)
+		}
+
+		if err := torrentInfo.AddPieceLayer(file.PiecesRoot, layer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readHashes returns the hashes answering request. Other messages are
+// applied to the session.
+func readHashes(peer *Peer, request *peerwire.HashRequestPayload) ([]byte, error) {
+	for {
+		var m peerwire.PeerMessage
+		if err := peerwire.UnmarshalPeerMessage(peer.conn, &m); err != nil {
+			return nil, fmt.Errorf("unmarshal hashes: %w", err)
+		}
+
+		switch m.ID {
+		case peerwire.IDHashes:
+			var hashes peerwire.HashesPayload
+			if err := hashes.UnmarshalBinary(m.Payload); err != nil {
+				return nil, fmt.Errorf("unmarshal hashes: %w", err)
+			}
+			if bytes.Equal(hashes.PiecesRoot, request.PiecesRoot) && hashes.Index == request.Index {
+				return hashes.Hashes, nil
+			}
+		case peerwire.IDHashReject:
+			var reject peerwire.HashRequestPayload
+			if err := reject.UnmarshalBinary(m.Payload); err != nil {
+				return nil, fmt.Errorf("unmarshal hash reject: %w", err)
+			}
+			if bytes.Equal(reject.PiecesRoot, request.PiecesRoot) && reject.Index == request.Index {
+				return nil, fmt.Errorf("hash request for %x rejected", request.PiecesRoot)
+			}
+		default:
+			if err := peer.handle(&m); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+func sendExtension(peer *Peer, p *peerwire.ExtensionPayload) error {
+	payload, err := p.MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("marshal extension: %w", err)
+	}
+	if err := peer.send(&peerwire.PeerMessage{ID: peerwire.IDExtension, Payload: payload}); err != nil {
+		return fmt.Errorf("send extension: %w", err)
+	}
+	return nil
+}
+
+// readExtension returns the payload of the next extension message from the
+// peer. Other messages are applied to the session.
+func readExtension(peer *Peer) ([]byte, error) {
+	for {
+		var m peerwire.PeerMessage
+		if err := peerwire.UnmarshalPeerMessage(peer.conn, &m); err != nil {
+			return nil, fmt.Errorf("unmarshal extension: %w", err)
+		}
+		if m.ID != peerwire.IDExtension {
+			if err := peer.handle(&m); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if len(m.Payload) == 0 {
+			return nil, fmt.Errorf("empty extension message")
+		}
+		return m.Payload, nil
+	}
+}
@@ -0,0 +1,30 @@
+package client
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"testing"
+)
+
+func TestMetadataMatches(t *testing.T) {
+	metadata := []byte("d4:name5:a.bine")
+	hash, hashV2 := sha1.Sum(metadata), sha256.Sum256(metadata)
+
+	tests := []struct {
+		name     string
+		infoHash []byte
+		want     bool
+	}{
+		{"sha1", hash[:], true},
+		{"truncated sha256", hashV2[:20], true},
+		{"sha256", hashV2[:], true},
+		{"sha256 prefix", hashV2[:8], false},
+		{"too long", append(hashV2[:], 0), false},
+		{"other", make([]byte, 20), false},
+	}
+	for _, test := range tests {
+		if got := metadataMatches(metadata, test.infoHash); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
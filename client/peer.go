@@ -0,0 +1,345 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/metainfo"
+	"github.com/codecrafters-io/bittorrent-starter-go/peerwire"
+)
+
+var (
+	errChoked         = errors.New("choked by peer")
+	errRequestTimeout = errors.New("request timed out")
+)
+
+// keepAliveInterval is how long we stay silent towards a peer before
+// sending a keep-alive.
+const keepAliveInterval = 2 * time.Minute
+
+// Peer is our connection to a single peer. It tracks who is choking and
+// interested on either side and which pieces the peer has.
+//
+// Until start is called the connection is used synchronously for the
+// handshake. Afterwards a reader goroutine owns the read side and messages
+// are consumed from msgs.
+type Peer struct {
+	conn net.Conn
+	addr string
+
+	amChoking      bool
+	amInterested   bool
+	peerChoking    bool
+	peerInterested bool
+	bitfield       peerwire.Bitfield
+
+	// extended is set when the peer supports the extension protocol.
+	// extensions and metadataSize come from its extension handshake.
+	extended     bool
+	extensions   map[string]int
+	metadataSize int
+
+	// onHave, when set, is called for every piece the peer announces after
+	// the session started tracking it.
+	onHave func(index int)
+
+	// onPEX, when set, is called with the peers added in ut_pex messages.
+	onPEX func(addrs []string)
+
+	// idleTimeout bounds every read once the reader goroutine runs, and
+	// requestTimeout every write and the wait for requested blocks. Zero
+	// means no limit.
+	idleTimeout    time.Duration
+	requestTimeout time.Duration
+	lastSent       time.Time
+
+	// downloaded counts the piece data received from the peer
+	downloaded rateMeter
+
+	msgs      chan peerwire.PeerMessage
+	readErr   error
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newPeer(conn net.Conn) *Peer {
+	return &Peer{
+		conn:        conn,
+		addr:        conn.RemoteAddr().String(),
+		amChoking:   true,
+		peerChoking: true,
+		closed:      make(chan struct{}),
+	}
+}
+
+// Addr returns the address of the peer.
+func (p *Peer) Addr() string {
+	return p.addr
+}
+
+func (p *Peer) Close() error {
+	p.closeOnce.Do(func() { close(p.closed) })
+	return p.conn.Close()
+}
+
+// start launches the reader goroutine. msgs is closed when reading fails,
+// after readErr has been set, or when the peer stays silent for longer than
+// idleTimeout.
+func (p *Peer) start() {
+	p.msgs = make(chan peerwire.PeerMessage)
+	go func() {
+		defer close(p.msgs)
+		for {
+			if p.idleTimeout > 0 {
+				if err := p.conn.SetReadDeadline(time.Now().Add(p.idleTimeout)); err != nil {
+					p.readErr = err
+					return
+				}
+			}
+
+			var m peerwire.PeerMessage
+			if err := peerwire.UnmarshalPeerMessage(p.conn, &m); err != nil {
+				if errors.Is(err, os.ErrDeadlineExceeded) {
+					err = fmt.Errorf("idle for %v", p.idleTimeout)
+				}
+				p.readErr = err
+				return
+			}
+			select {
+			case p.msgs <- m:
+			case <-p.closed:
+				return
+			}
+		}
+	}()
+}
+
+// handle updates the session state from a message received from the peer.
+func (p *Peer) handle(m *peerwire.PeerMessage) error {
+	switch m.ID {
+	case peerwire.IDChoke:
+		p.peerChoking = true
+	case peerwire.IDUnchoke:
+		p.peerChoking = false
+	case peerwire.IDInterested:
+		p.peerInterested = true
+	case peerwire.IDNotInterested:
+		p.peerInterested = false
+	case peerwire.IDHave:
+		if len(m.Payload) != 4 {
+			return fmt.Errorf("invalid have length %d", len(m.Payload))
+		}
+		p.setHave(int(binary.BigEndian.Uint32(m.Payload)))
+	case peerwire.IDBitfield:
+		old := p.bitfield
+		p.bitfield = append(peerwire.Bitfield(nil), m.Payload...)
+		if p.onHave != nil {
+			for i := 0; i < len(p.bitfield)*8; i++ {
+				if p.bitfield.Has(i) && !old.Has(i) {
+					p.onHave(i)
+				}
+			}
+		}
+	case peerwire.IDExtension:
+		return p.handleExtension(m.Payload)
+	}
+	return nil
+}
+
+// handleExtension records the peer's extension handshake and passes on the
+// peers from ut_pex messages. Other extension messages are ignored.
+func (p *Peer) handleExtension(payload []byte) error {
+	if len(payload) == 0 {
+		return fmt.Errorf("empty extension message")
+	}
+
+	switch payload[0] {
+	case 0:
+		var extension peerwire.ExtensionPayload
+		if err := extension.UnmarshalBinary(payload); err != nil {
+			return fmt.Errorf("unmarshal extension: %w", err)
+		}
+		message, _ := extension.Message.(map[string]any)
+		extensions, _ := message["m"].(map[string]any)
+		if p.extensions == nil {
+			p.extensions = map[string]int{}
+		}
+		// later handshakes update single extensions, id 0 disables one
+		for name, id := range extensions {
+			if id, ok := id.(int64); ok {
+				p.extensions[name] = int(id)
+			}
+		}
+		if size, ok := message["metadata_size"].(int64); ok {
+			p.metadataSize = int(size)
+		}
+	case utPexID:
+		var pex peerwire.PEXMessage
+		if err := pex.UnmarshalBinary(payload[1:]); err != nil {
+			return err
+		}
+		if p.onPEX != nil {
+			p.onPEX(pex.Added)
+		}
+	}
+	return nil
+}
+
+// setHave marks index as available, growing the bitfield when the peer has
+// not sent one or the piece count is not known yet.
+func (p *Peer) setHave(index int) {
+	if index < 0 || p.bitfield.Has(index) {
+		return
+	}
+	if need := index/8 + 1; len(p.bitfield) < need {
+		p.bitfield = append(p.bitfield, make(peerwire.Bitfield, need-len(p.bitfield))...)
+	}
+	p.bitfield.Set(index)
+	if p.onHave != nil {
+		p.onHave(index)
+	}
+}
+
+// send writes m to the peer and tracks our own choke and interest state.
+func (p *Peer) send(m *peerwire.PeerMessage) error {
+	if p.requestTimeout > 0 {
+		if err := p.conn.SetWriteDeadline(time.Now().Add(p.requestTimeout)); err != nil {
+			return err
+		}
+	}
+	if err := peerwire.MarshalPeerMessage(p.conn, m); err != nil {
+		return err
+	}
+	p.lastSent = time.Now()
+
+	switch m.ID {
+	case peerwire.IDChoke:
+		p.amChoking = true
+	case peerwire.IDUnchoke:
+		p.amChoking = false
+	case peerwire.IDInterested:
+		p.amInterested = true
+	case peerwire.IDNotInterested:
+		p.amInterested = false
+	}
+	return nil
+}
+
+// setInterested sends interested or not interested when our interest in the
+// peer changes.
+func (p *Peer) setInterested(interested bool) error {
+	if interested == p.amInterested {
+		return nil
+	}
+
+	m := peerwire.PeerMessage{ID: peerwire.IDNotInterested}
+	if interested {
+		m.ID = peerwire.IDInterested
+	}
+	return p.send(&m)
+}
+
+// dialPeer connects and performs the handshake with the peer. For magnets
+// it also fetches the torrent metadata and any missing v2 piece layers.
+// Cancelling ctx aborts the connection attempt and the handshake, which
+// are also bounded by the connect and handshake timeouts.
+func (c *Client) dialPeer(ctx context.Context, peerAddr string, infoHash []byte, isMagnet bool) (*Peer, *metainfo.TorrentInfo, error) {
+	dialer := net.Dialer{Timeout: c.connectTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", peerAddr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("dial: %w", err)
+	}
+
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	peer := newPeer(conn)
+	peer.idleTimeout = c.idleTimeout
+	peer.requestTimeout = c.requestTimeout
+	if err := setDeadline(conn, c.handshakeTimeout); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	torrentInfo, err := handshakePeer(peer, c.peerID, infoHash, isMagnet)
+	if err != nil {
+		conn.Close()
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			err = fmt.Errorf("handshake timed out: %w", err)
+		}
+		return nil, nil, err
+	}
+	if !stop() {
+		return nil, nil, ctx.Err()
+	}
+	if err := setDeadline(conn, 0); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	return peer, torrentInfo, nil
+}
+
+// handshakePeer performs the handshake with the peer and, for magnets,
+// fetches the torrent metadata and any missing v2 piece layers from it.
+func handshakePeer(peer *Peer, peerID, infoHash []byte, isMagnet bool) (*metainfo.TorrentInfo, error) {
+	conn := peer.conn
+	handshakeMessage := peerwire.HandshakeMessage{
+		Protocol: peerwire.Protocol,
+		InfoHash: infoHash,
+		PeerID:   peerID,
+	}
+	handshakeMessage.SetExtension()
+	if err := peerwire.MarshalHandshakeMessage(conn, &handshakeMessage); err != nil {
+		return nil, fmt.Errorf("marshal handshake: %w", err)
+	}
+	if err := peerwire.UnmarshalHandshakeMessage(conn, &handshakeMessage); err != nil {
+		return nil, fmt.Errorf("unmarshal handshake: %w", err)
+	}
+	peer.extended = handshakeMessage.IsExtension()
+
+	if isMagnet && !peer.extended {
+		return nil, fmt.Errorf("extension not supported")
+	}
+
+	if isMagnet {
+		torrentInfo, err := fetchMetadata(peer, infoHash)
+		if err != nil {
+			return nil, err
+		}
+		if err := fetchPieceLayers(peer, torrentInfo); err != nil {
+			return nil, err
+		}
+		return torrentInfo, nil
+	}
+
+	// the bitfield, which peers without pieces may leave out, is handled
+	// with the other messages once the session runs
+	return nil, nil
+}
+
+// dialMetadataPeer tries the peers in turn until one of them hands out
+// metadata matching infoHash.
+func (c *Client) dialMetadataPeer(ctx context.Context, peers []string, infoHash []byte) (*Peer, *metainfo.TorrentInfo, error) {
+	if len(peers) == 0 {
+		return nil, nil, fmt.Errorf("no peers")
+	}
+
+	var errs []error
+	for _, peerAddr := range peers {
+		peer, torrentInfo, err := c.dialPeer(ctx, peerAddr, infoHash, true)
+		if err == nil {
+			return peer, torrentInfo, nil
+		}
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		errs = append(errs, fmt.Errorf("peer %s: %w", peerAddr, err))
+	}
+	return nil, nil, errors.Join(errs...)
+}
@@ -0,0 +1,136 @@
+package client
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/peerwire"
+)
+
+// https://www.bittorrent.org/beps/bep_0011.html
+
+// utPexID is the extension message id we assign to ut_pex in our extension
+// handshake.
+const utPexID = 2
+
+// pexInterval is how often we send a peer the changes to our peer set.
+var pexInterval = time.Minute
+
+// maxPEXPeers bounds the added and dropped lists of a single message.
+const maxPEXPeers = 50
+
+// peerPool is the set of peers a download is connected to. Workers share it
+// with their peers through ut_pex and report the peers they learn about,
+// which the download then connects to. A nil pool disables peer exchange,
+// as for private torrents.
+type peerPool struct {
+	mu        sync.Mutex
+	connected map[string]bool
+	learned   chan []string
+}
+
+func newPeerPool() *peerPool {
+	return &peerPool{
+		connected: map[string]bool{},
+		learned:   make(chan []string, 16),
+	}
+}
+
+func (p *peerPool) add(addr string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.connected[addr] = true
+}
+
+func (p *peerPool) remove(addr string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.connected, addr)
+}
+
+// learn reports addresses received from a peer. When the download is busy
+// they are dropped; other peers will mention them again.
+func (p *peerPool) learn(addrs []string) {
+	if p == nil || len(addrs) == 0 {
+		return
+	}
+	select {
+	case p.learned <- addrs:
+	default:
+	}
+}
+
+// Learned delivers the addresses reported by learn.
+func (p *peerPool) Learned() <-chan []string {
+	if p == nil {
+		return nil
+	}
+	return p.learned
+}
+
+// pexSender keeps track of what we told a single peer about our peer set.
+type pexSender struct {
+	sent map[string]bool
+	last time.Time
+}
+
+// due reports whether the next message may be sent.
+func (s *pexSender) due() bool {
+	return time.Since(s.last) >= pexInterval
+}
+
+// send tells the peer which peers we connected to and disconnected from
+// since the previous message. Peers that don't support ut_pex are skipped.
+func (s *pexSender) send(peer *Peer, pool *peerPool) error {
+	s.last = time.Now()
+	id := peer.extensions["ut_pex"]
+	if id <= 0 || id > 255 {
+		return nil
+	}
+	if s.sent == nil {
+		s.sent = map[string]bool{}
+	}
+
+	pool.mu.Lock()
+	var m peerwire.PEXMessage
+	for addr := range pool.connected {
+		if addr != peer.addr && !s.sent[addr] && len(m.Added) < maxPEXPeers {
+			m.Added = append(m.Added, addr)
+			m.AddedFlags = append(m.AddedFlags, peerwire.PEXOutgoing)
+		}
+	}
+	for addr := range s.sent {
+		if !pool.connected[addr] && len(m.Dropped) < maxPEXPeers {
+			m.Dropped = append(m.Dropped, addr)
+		}
+	}
+	pool.mu.Unlock()
+
+	if len(m.Added) == 0 && len(m.Dropped) == 0 {
+		return nil
+	}
+	for _, addr := range m.Added {
+		s.sent[addr] = true
+	}
+	for _, addr := range m.Dropped {
+		delete(s.sent, addr)
+	}
+
+	payload, err := m.MarshalBinary()
+	if err != nil {
+		return fmt.Errorf("marshal pex: %w", err)
+	}
+	if err := peer.send(&peerwire.PeerMessage{ID: peerwire.IDExtension, Payload: append([]byte{byte(id)}, payload...)}); err != nil {
+		return fmt.Errorf("send pex: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package client
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// EventType names what happened in an Event.
+type EventType string
+
+// Events reported while a torrent downloads.
+const (
+	EventPeerConnected EventType = "peer_connected"
+	EventPeerDropped   EventType = "peer_dropped"
+	EventPieceVerified EventType = "piece_verified"
+	EventHashFailed    EventType = "hash_failed"
+	EventCompleted     EventType = "completed"
+)
+
+// Event is something that happened to a download. Piece is set for piece
+// events and Source names the peer address or web seed URL involved; Err
+// is why a peer was dropped. Progress is the state of the download right
+// after the event.
+type Event struct {
+	Type     EventType
+	Time     time.Time
+	InfoHash []byte
+	Piece    int
+	Source   string
+	Err      error
+	Progress Progress
+}
+
+// Progress is a snapshot of the state of a download. The piece counts are
+// zero until the metadata of a magnet is known.
+type Progress struct {
+	Pieces     int
+	PieceCount int
+
+	// Downloaded and Uploaded count the bytes transferred by this download,
+	// Left the bytes still missing.
+	Downloaded int
+	Uploaded   int
+	Left       int
+
+	// Rate is the download rate in bytes per second over the last few
+	// seconds, and ETA the time left at that rate; zero while stalled.
+	Rate float64
+	ETA  time.Duration
+
+	// Peers are the peers we are downloading from.
+	Peers []PeerProgress
+}
+
+// PeerProgress is a snapshot of the transfer with a single peer.
+type PeerProgress struct {
+	Addr       string
+	Downloaded int
+	Rate       float64
+}
+
+// reporter tracks the connected peers and the download rate of a download
+// and passes its events on. The methods are safe on a nil receiver, which
+// reports nothing.
+type reporter struct {
+	infoHash []byte
+	onEvent  func(Event)
+	progress func() Progress
+	rate     rateMeter
+
+	mu    sync.Mutex
+	peers map[*Peer]bool
+}
+
+func newReporter(infoHash []byte, onEvent func(Event), progress func() Progress) *reporter {
+	return &reporter{infoHash: infoHash, onEvent: onEvent, progress: progress, peers: map[*Peer]bool{}}
+}
+
+func (r *reporter) emit(event Event) {
+	if r == nil || r.onEvent == nil {
+		return
+	}
+	event.Time = time.Now()
+	event.InfoHash = r.infoHash
+	event.Progress = r.progress()
+	r.onEvent(event)
+}
+
+// received counts n bytes of piece data from any source.
+func (r *reporter) received(n int) {
+	if r == nil {
+		return
+	}
+	r.rate.add(n)
+}
+
+func (r *reporter) peerConnected(peer *Peer) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.peers[peer] = true
+	r.mu.Unlock()
+	r.emit(Event{Type: EventPeerConnected, Source: peer.addr})
+}
+
+// peerDone forgets the peer; err reports why it was dropped, if it was.
+func (r *reporter) peerDone(peer *Peer, err error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	delete(r.peers, peer)
+	r.mu.Unlock()
+	if err != nil {
+		r.emit(Event{Type: EventPeerDropped, Source: peer.addr, Err: err})
+	}
+}
+
+func (r *reporter) piece(index int, verified bool, source string) {
+	eventType := EventPieceVerified
+	if !verified {
+		eventType = EventHashFailed
+	}
+	r.emit(Event{Type: eventType, Piece: index, Source: source})
+}
+
+func (r *reporter) completed() {
+	r.emit(Event{Type: EventCompleted})
+}
+
+// fill adds the rates and peers to progress.
+func (r *reporter) fill(progress *Progress) {
+	if r == nil {
+		return
+	}
+	progress.Rate = r.rate.rate()
+	if progress.Rate > 0 {
+		progress.ETA = time.Duration(float64(progress.Left) / progress.Rate * float64(time.Second)).Round(time.Second)
+	}
+
+	r.mu.Lock()
+	for peer := range r.peers {
+		progress.Peers = append(progress.Peers, PeerProgress{
+			Addr:       peer.addr,
+			Downloaded: int(peer.downloaded.total()),
+			Rate:       peer.downloaded.rate(),
+		})
+	}
+	r.mu.Unlock()
+	sort.Slice(progress.Peers, func(i, j int) bool { return progress.Peers[i].Addr < progress.Peers[j].Addr })
+}
+
+// Rates are averaged over rateWindow, sampling the byte count at most every
+// rateSampleInterval.
+const (
+	rateWindow         = 5 * time.Second
+	rateSampleInterval = 500 * time.Millisecond
+)
+
+type rateSample struct {
+	time  time.Time
+	bytes int64
+}
+
+// rateMeter measures a transfer rate. The zero value is ready to use.
+type rateMeter struct {
+	mu      sync.Mutex
+	bytes   int64
+	samples []rateSample
+}
+
+func (m *rateMeter) add(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if len(m.samples) == 0 || now.Sub(m.samples[len(m.samples)-1].time) >= rateSampleInterval {
+		m.samples = append(m.samples, rateSample{time: now, bytes: m.bytes})
+	}
+	m.bytes += int64(n)
+	m.prune(now)
+}
+
+// prune drops the samples that fell out of the window. It must be called
+// with m.mu held.
+func (m *rateMeter) prune(now time.Time) {
+	i := 0
+	for i < len(m.samples) && now.Sub(m.samples[i].time) > rateWindow {
+		i++
+	}
+	m.samples = m.samples[i:]
+}
+
+func (m *rateMeter) total() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.bytes
+}
+
+// rate returns the bytes per second since the oldest sample in the window.
+func (m *rateMeter) rate() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.prune(now)
+	if len(m.samples) == 0 {
+		return 0
+	}
+	oldest := m.samples[0]
+	elapsed := now.Sub(oldest.time).Seconds()
+	if elapsed < rateSampleInterval.Seconds() {
+		return 0
+	}
+	return float64(m.bytes-oldest.bytes) / elapsed
+}
@@ -0,0 +1,298 @@
+package client
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/metainfo"
+	"github.com/codecrafters-io/bittorrent-starter-go/peerwire"
+	"github.com/codecrafters-io/bittorrent-starter-go/tracker"
+)
+
+// randomFirstPieces is how many pieces are picked at random before switching
+// to rarest-first, so we quickly have something to trade.
+const randomFirstPieces = 4
+
+type task struct {
+	pieceIndex int
+}
+
+// activePiece is a piece being downloaded. In endgame mode several workers
+// fetch the same piece and share its blocks.
+type activePiece struct {
+	task     task
+	data     []byte
+	received []bool
+	missing  int
+	workers  int
+	done     bool
+}
+
+// pieceQueue hands out pieces to peer workers. A worker only receives pieces
+// its peer has: first pieces another worker abandoned half way, then new
+// pieces rarest-first across the swarm, and finally, in endgame mode, pieces
+// already in flight with other peers.
+type pieceQueue struct {
+	torrentInfo *metainfo.TorrentInfo
+	stats       *tracker.Stats
+	report      *reporter
+	logger      *log.Logger
+
+	mu           sync.Mutex
+	pending      []task
+	active       map[int]*activePiece
+	availability []int
+	completed    int
+	remaining    int
+	changed      chan struct{}
+}
+
+func newPieceQueue(torrentInfo *metainfo.TorrentInfo, tasks []task) *pieceQueue {
+	return &pieceQueue{
+		torrentInfo:  torrentInfo,
+		pending:      append([]task(nil), tasks...),
+		active:       map[int]*activePiece{},
+		availability: make([]int, torrentInfo.PieceCount()),
+		remaining:    len(tasks),
+		changed:      make(chan struct{}),
+	}
+}
+
+// notify wakes everyone waiting on changed. It must be called with q.mu held.
+func (q *pieceQueue) notify() {
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// Changed returns a channel that is closed the next time a block arrives or
+// a piece is taken back, completed or abandoned.
+func (q *pieceQueue) Changed() <-chan struct{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.changed
+}
+
+// AddPeer counts the pieces of a newly connected peer towards availability.
+func (q *pieceQueue) AddPeer(bitfield peerwire.Bitfield) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i := range q.availability {
+		if bitfield.Has(i) {
+			q.availability[i]++
+		}
+	}
+}
+
+// RemovePeer undoes AddPeer and PeerHas for a disconnected peer.
+func (q *pieceQueue) RemovePeer(bitfield peerwire.Bitfield) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i := range q.availability {
+		if bitfield.Has(i) {
+			q.availability[i]--
+		}
+	}
+}
+
+// PeerHas records that a connected peer announced piece index.
+func (q *pieceQueue) PeerHas(index int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if index >= 0 && index < len(q.availability) {
+		q.availability[index]++
+		q.notify()
+	}
+}
+
+// Take picks the next piece for a worker whose peer has the pieces for which
+// has reports true.
+func (q *pieceQueue) Take(has func(int) bool) (*activePiece, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// resume pieces nobody is working on
+	for _, piece := range q.active {
+		if piece.workers == 0 && piece.missing > 0 && has(piece.task.pieceIndex) {
+			piece.workers++
+			return piece, true
+		}
+	}
+
+	var candidates []int
+	for i, t := range q.pending {
+		if has(t.pieceIndex) {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) > 0 {
+		i := q.pick(candidates)
+		t := q.pending[i]
+		q.pending = append(q.pending[:i], q.pending[i+1:]...)
+
+		size := q.torrentInfo.PieceSize(t.pieceIndex)
+		blockCount := (size + blockSize - 1) / blockSize
+		piece := &activePiece{
+			task:     t,
+			data:     make([]byte, size),
+			received: make([]bool, blockCount),
+			missing:  blockCount,
+			workers:  1,
+		}
+		q.active[t.pieceIndex] = piece
+		return piece, true
+	}
+
+	// endgame: everything left is in flight, so help the slowest piece
+	if len(q.pending) > 0 {
+		return nil, false
+	}
+	var best *activePiece
+	for _, piece := range q.active {
+		if piece.missing > 0 && has(piece.task.pieceIndex) && (best == nil || piece.workers < best.workers) {
+			best = piece
+		}
+	}
+	if best == nil {
+		return nil, false
+	}
+	best.workers++
+	return best, true
+}
+
+// pick returns the index into q.pending of the piece to download next. It
+// must be called with q.mu held.
+func (q *pieceQueue) pick(candidates []int) int {
+	if q.completed < randomFirstPieces {
+		return candidates[rand.Intn(len(candidates))]
+	}
+
+	var rarest []int
+	for _, i := range candidates {
+		index := q.pending[i].pieceIndex
+		switch {
+		case len(rarest) == 0 || q.availability[index] < q.availability[q.pending[rarest[0]].pieceIndex]:
+			rarest = []int{i}
+		case q.availability[index] == q.availability[q.pending[rarest[0]].pieceIndex]:
+			rarest = append(rarest, i)
+		}
+	}
+	return rarest[rand.Intn(len(rarest))]
+}
+
+// Wants reports whether Take would return a piece for has.
+func (q *pieceQueue) Wants(has func(int) bool) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, t := range q.pending {
+		if has(t.pieceIndex) {
+			return true
+		}
+	}
+	for _, piece := range q.active {
+		if piece.missing > 0 && has(piece.task.pieceIndex) {
+			return true
+		}
+	}
+	return false
+}
+
+// blockState returns whether the piece no longer needs blocks from this
+// worker and which of the blocks it requested have arrived from elsewhere.
+func (q *pieceQueue) blockState(piece *activePiece, requested map[int]bool) (done bool, arrived []int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for block := range requested {
+		if piece.done || piece.received[block] {
+			arrived = append(arrived, block)
+		}
+	}
+	return piece.done || piece.missing == 0, arrived
+}
+
+// nextBlocks returns up to n blocks of the piece that are still missing and
+// not yet requested by this worker.
+func (q *pieceQueue) nextBlocks(piece *activePiece, requested map[int]bool, n int) []int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var blocks []int
+	for block, received := range piece.received {
+		if len(blocks) >= n {
+			break
+		}
+		if !received && !requested[block] {
+			blocks = append(blocks, block)
+		}
+	}
+	return blocks
+}
+
+// storeBlock copies a block received from a peer into the piece. It reports
+// whether this block completed the piece; the caller then verifies it and
+// calls Finish.
+func (q *pieceQueue) storeBlock(piece *activePiece, begin int, data []byte) (complete bool, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	block := begin / blockSize
+	if begin%blockSize != 0 || block >= len(piece.received) ||
+		len(data) != min(blockSize, len(piece.data)-begin) {
+		return false, fmt.Errorf("unexpected block %d+%d", begin, len(data))
+	}
+	q.stats.AddDownloaded(len(data))
+	q.report.received(len(data))
+	if piece.done || piece.received[block] {
+		return false, nil
+	}
+
+	copy(piece.data[begin:], data)
+	piece.received[block] = true
+	piece.missing--
+	q.notify()
+	return piece.missing == 0, nil
+}
+
+// Release is called by a worker that stops fetching the piece, whether it
+// is done, gave up or was choked. Pieces nobody works on stay active so the
+// blocks received so far are kept for the next worker.
+func (q *pieceQueue) Release(piece *activePiece) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	piece.workers--
+	q.notify()
+}
+
+// Finish completes a piece after its hash was checked. A piece that failed
+// verification is discarded and downloaded again from scratch.
+func (q *pieceQueue) Finish(piece *activePiece, verified bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	piece.done = true
+	delete(q.active, piece.task.pieceIndex)
+	if verified {
+		q.completed++
+		q.remaining--
+		q.stats.AddLeft(-len(piece.data))
+	} else {
+		q.pending = append(q.pending, piece.task)
+	}
+	q.notify()
+}
+
+// Finished reports whether every piece has completed.
+func (q *pieceQueue) Finished() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.remaining == 0
+}
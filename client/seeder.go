@@ -0,0 +1,238 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/metainfo"
+	"github.com/codecrafters-io/bittorrent-starter-go/peerwire"
+	"github.com/codecrafters-io/bittorrent-starter-go/tracker"
+)
+
+// maxRequestLength is the largest block we serve in a single piece message.
+const maxRequestLength = peerwire.MaxBlockLength
+
+type seedTorrent struct {
+	info     *metainfo.TorrentInfo
+	content  io.ReaderAt
+	bitfield peerwire.Bitfield
+	stats    tracker.Stats
+}
+
+// Seeder accepts inbound peer connections and serves blocks of the torrents
+// registered with Add.
+type Seeder struct {
+	listener net.Listener
+	peerID   []byte
+
+	// peers get handshakeTimeout to complete the handshake and are dropped
+	// when they send nothing for idleTimeout
+	handshakeTimeout time.Duration
+	idleTimeout      time.Duration
+
+	// closed tells the client the port is no longer listened on
+	closed func()
+	logger *log.Logger
+
+	mu       sync.Mutex
+	torrents map[string]*seedTorrent
+}
+
+// NewSeeder listens for peers on the TCP address addr. Until it is closed,
+// its port is the one the client advertises to trackers and the DHT.
+func (c *Client) NewSeeder(addr string) (*Seeder, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	c.setListenPort(0, port)
+	return &Seeder{
+		listener:         listener,
+		peerID:           c.peerID,
+		handshakeTimeout: c.handshakeTimeout,
+		idleTimeout:      c.idleTimeout,
+		closed:           func() { c.setListenPort(port, 0) },
+		logger:           c.logger,
+		torrents:         map[string]*seedTorrent{},
+	}, nil
+}
+
+func (s *Seeder) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Add makes the pieces set in bitfield available to peers asking for the
+// torrent described by torrentInfo, reading them from content.
+func (s *Seeder) Add(torrentInfo *metainfo.TorrentInfo, content io.ReaderAt, bitfield peerwire.Bitfield) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	torrent := &seedTorrent{
+		info:     torrentInfo,
+		content:  content,
+		bitfield: bitfield,
+	}
+	s.torrents[string(torrentInfo.Hash())] = torrent
+	if torrentInfo.IsV2() {
+		// peers may join hybrid torrents with either hash
+		s.torrents[string(torrentInfo.HashV2()[:20])] = torrent
+	}
+}
+
+// Stats returns the transfer counters of the torrent with infoHash, or nil
+// if it was not added.
+func (s *Seeder) Stats(infoHash []byte) *tracker.Stats {
+	torrent := s.lookup(infoHash)
+	if torrent == nil {
+		return nil
+	}
+	return &torrent.stats
+}
+
+func (s *Seeder) lookup(infoHash []byte) *seedTorrent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.torrents[string(infoHash)]
+}
+
+// Serve accepts connections until the seeder is closed.
+func (s *Seeder) Serve() error {
+	for {
+		conn, err := s.listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("accept: %w", err)
+		}
+
+		go func() {
+			defer conn.Close()
+			if err := s.handle(conn); err != nil && !errors.Is(err, io.EOF) {
+				s.logger.Printf("peer %s: %v", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+func (s *Seeder) Close() error {
+	s.closed()
+	return s.listener.Close()
+}
+
+func (s *Seeder) handle(conn net.Conn) error {
+	if err := setDeadline(conn, s.handshakeTimeout); err != nil {
+		return err
+	}
+
+	var handshake peerwire.HandshakeMessage
+	if err := peerwire.UnmarshalHandshakeMessage(conn, &handshake); err != nil {
+		return fmt.Errorf("unmarshal handshake: %w", err)
+	}
+	if handshake.Protocol != peerwire.Protocol {
+		return fmt.Errorf("unknown protocol %q", handshake.Protocol)
+	}
+
+	torrent := s.lookup(handshake.InfoHash)
+	if torrent == nil {
+		return fmt.Errorf("unknown info hash %x", handshake.InfoHash)
+	}
+
+	handshake = peerwire.HandshakeMessage{
+		Protocol: peerwire.Protocol,
+		InfoHash: handshake.InfoHash,
+		PeerID:   s.peerID,
+	}
+	if err := peerwire.MarshalHandshakeMessage(conn, &handshake); err != nil {
+		return fmt.Errorf("marshal handshake: %w", err)
+	}
+
+	m := peerwire.PeerMessage{ID: peerwire.IDBitfield, Payload: torrent.bitfield}
+	if err := peerwire.MarshalPeerMessage(conn, &m); err != nil {
+		return fmt.Errorf("marshal bitfield: %w", err)
+	}
+
+	unchoked := false
+	for {
+		if err := setDeadline(conn, s.idleTimeout); err != nil {
+			return err
+		}
+		if err := peerwire.UnmarshalPeerMessage(conn, &m); err != nil {
+			return err
+		}
+
+		switch m.ID {
+		case peerwire.IDInterested:
+			if unchoked {
+				continue
+			}
+			m = peerwire.PeerMessage{ID: peerwire.IDUnchoke}
+			if err := peerwire.MarshalPeerMessage(conn, &m); err != nil {
+				return fmt.Errorf("marshal unchoke: %w", err)
+			}
+			unchoked = true
+		case peerwire.IDRequest:
+			if !unchoked {
+				continue
+			}
+
+			var request peerwire.RequestPayload
+			if err := request.UnmarshalBinary(m.Payload); err != nil {
+				return fmt.Errorf("unmarshal request: %w", err)
+			}
+			block, err := torrent.readBlock(&request)
+			if err != nil {
+				return err
+			}
+
+			payload, err := (&peerwire.PiecePayload{
+				Index: request.Index,
+				Begin: request.Begin,
+				Block: block,
+			}).MarshalBinary()
+			if err != nil {
+				return fmt.Errorf("marshal piece: %w", err)
+			}
+			m = peerwire.PeerMessage{ID: peerwire.IDPiece, Payload: payload}
+			if err := peerwire.MarshalPeerMessage(conn, &m); err != nil {
+				return fmt.Errorf("marshal piece: %w", err)
+			}
+			torrent.stats.AddUploaded(len(block))
+		}
+	}
+}
+
+func (t *seedTorrent) readBlock(request *peerwire.RequestPayload) ([]byte, error) {
+	index := int(request.Index)
+	if !t.bitfield.Has(index) {
+		return nil, fmt.Errorf("request for missing piece %d", index)
+	}
+	if request.Length == 0 || request.Length > maxRequestLength ||
+		int(request.Begin)+int(request.Length) > t.info.PieceSize(index) {
+		return nil, fmt.Errorf("invalid request %d+%d in piece %d", request.Begin, request.Length, index)
+	}
+
+	block := make([]byte, request.Length)
+	offset := int64(index)*int64(t.info.PieceLength) + int64(request.Begin)
+	if _, err := t.content.ReadAt(block, offset); err != nil {
+		return nil, fmt.Errorf("read block: %w", err)
+	}
+	return block, nil
+}
+
+// setDeadline limits the reads and writes on conn to the next timeout,
+// leaving them unbounded for a zero timeout.
+func setDeadline(conn net.Conn, timeout time.Duration) error {
+	if timeout <= 0 {
+		return conn.SetDeadline(time.Time{})
+	}
+	return conn.SetDeadline(time.Now().Add(timeout))
+}
@@ -0,0 +1,464 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/metainfo"
+	"github.com/codecrafters-io/bittorrent-starter-go/peerwire"
+	"github.com/codecrafters-io/bittorrent-starter-go/storage"
+	"github.com/codecrafters-io/bittorrent-starter-go/tracker"
+)
+
+// swarm is what a download draws on: the connected peers, the addresses of
+// peers not dialed yet, web seeds and the addresses of peers learned while
+// the download runs, such as from tracker re-announces. Transfers are
+// counted in stats and report.
+type swarm struct {
+	infoHash []byte
+	peers    []*Peer
+	addrs    []string
+	webSeeds []string
+	more     <-chan []string
+	stats    *tracker.Stats
+	report   *reporter
+}
+
+// downloadAll downloads the pieces of torrentInfo missing from store from
+// the swarm. The store decides where the content goes and how progress is
+// kept for an interrupted download.
+func (c *Client) downloadAll(ctx context.Context, store storage.Storage, torrentInfo *metainfo.TorrentInfo, s *swarm) error {
+	completed := store.Completed()
+
+	var tasks []task
+	for i := 0; i < torrentInfo.PieceCount(); i++ {
+		if !completed.Has(i) {
+			tasks = append(tasks, task{pieceIndex: i})
+		}
+	}
+
+	return c.downloadTasks(ctx, s, torrentInfo, tasks, store)
+}
+
+// missingLength returns the bytes of the pieces of torrentInfo that are not
+// in completed.
+func missingLength(torrentInfo *metainfo.TorrentInfo, completed peerwire.Bitfield) int {
+	left := 0
+	for i := 0; i < torrentInfo.PieceCount(); i++ {
+		if !completed.Has(i) {
+			left += torrentInfo.PieceSize(i)
+		}
+	}
+	return left
+}
+
+// downloadTasks runs a worker per peer and web seed until every task is
+// done, connecting to new peers of the swarm as they are learned. A worker
+// that fails hands its task back to the others; downloadTasks only gives up
+// once no worker is left. When the tasks are done or ctx is cancelled the
+// peers are disconnected and downloadTasks returns once every worker has
+// stopped.
+func (c *Client) downloadTasks(ctx context.Context, s *swarm, torrentInfo *metainfo.TorrentInfo, tasks []task, store storage.Storage) error {
+	// workCtx stops the workers once the tasks are done
+	workCtx, stopWork := context.WithCancel(ctx)
+	defer stopWork()
+
+	queue := newPieceQueue(torrentInfo, tasks)
+	queue.stats = s.stats
+	queue.report = s.report
+	queue.logger = c.logger
+
+	// peer exchange is not allowed for private torrents
+	var pool *peerPool
+	if torrentInfo.Private != 1 {
+		pool = newPeerPool()
+	}
+
+	left := 0
+	for _, t := range tasks {
+		left += torrentInfo.PieceSize(t.pieceIndex)
+	}
+	s.stats.SetLeft(left)
+
+	// exited is signalled whenever a worker stops; the loop below then
+	// checks whether any are still running
+	var running atomic.Int32
+	exited := make(chan struct{}, 1)
+	work := func(run func()) {
+		running.Add(1)
+		go func() {
+			defer func() {
+				running.Add(-1)
+				select {
+				case exited <- struct{}{}:
+				default:
+				}
+			}()
+			run()
+		}()
+	}
+
+	runPeer := func(peer *Peer) {
+		stop := context.AfterFunc(workCtx, func() { peer.Close() })
+		defer stop()
+
+		s.report.peerConnected(peer)
+		err := downloadFromPeer(peer, torrentInfo, queue, pool, store, c.pipeline)
+		if err != nil {
+			if workCtx.Err() != nil {
+				err = nil
+			} else {
+				c.logger.Printf("peer %s: %v", peer.addr, err)
+			}
+			peer.Close()
+		}
+		s.report.peerDone(peer, err)
+	}
+
+	// peerWorkers counts the workers of peers, connected or being dialed
+	var peerWorkers atomic.Int32
+	workPeer := func(run func()) {
+		peerWorkers.Add(1)
+		work(func() {
+			defer peerWorkers.Add(-1)
+			run()
+		})
+	}
+
+	known := map[string]bool{}
+	for _, peer := range s.peers {
+		known[peer.addr] = true
+		workPeer(func() { runPeer(peer) })
+	}
+	for _, seedURL := range s.webSeeds {
+		work(func() {
+			if err := downloadWebSeed(workCtx, seedURL, torrentInfo, queue, store, c.requestTimeout); err != nil && workCtx.Err() == nil {
+				c.logger.Printf("web seed %s: %v", seedURL, err)
+			}
+		})
+	}
+
+	// connect queues the addresses not seen before; dialPending starts
+	// workers for them while fewer than c.maxPeers peers are connected or
+	// being dialed
+	var pending []string
+	connect := func(addrs []string) {
+		for _, addr := range addrs {
+			if !known[addr] {
+				known[addr] = true
+				pending = append(pending, addr)
+			}
+		}
+	}
+	dialPending := func() {
+		for len(pending) > 0 && int(peerWorkers.Load()) < c.maxPeers {
+			addr := pending[0]
+			pending = pending[1:]
+			workPeer(func() {
+				peer, _, err := c.dialPeer(workCtx, addr, s.infoHash, false)
+				if err != nil {
+					if workCtx.Err() == nil {
+						c.logger.Printf("peer %s: %v", addr, err)
+					}
+					return
+				}
+				defer peer.Close()
+				runPeer(peer)
+			})
+		}
+	}
+	connect(s.addrs)
+
+	for {
+		changed := queue.Changed()
+		if queue.Finished() {
+			stopWork()
+		}
+		if workCtx.Err() == nil {
+			dialPending()
+		}
+		if running.Load() == 0 {
+			if queue.Finished() {
+				return nil
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			return fmt.Errorf("all peers failed")
+		}
+
+		// once stopped, only wait for the workers to exit
+		more, learned := s.more, pool.Learned()
+		if workCtx.Err() != nil {
+			more, learned = nil, nil
+		}
+		select {
+		case <-changed:
+		case <-exited:
+		case addrs := <-more:
+			connect(addrs)
+		case addrs := <-learned:
+			connect(addrs)
+		}
+	}
+}
+
+// downloadFromPeer downloads pieces from the peer until the queue is
+// finished. Only pieces the peer has are taken, and requests pause while the
+// peer is choking us; meanwhile keep-alives stop the peer from dropping us.
+// On error the piece in progress is released for other workers. The requests
+// kept outstanding follow the rate of the peer within the bounds of pipeline.
+func downloadFromPeer(peer *Peer, torrentInfo *metainfo.TorrentInfo, queue *pieceQueue, pool *peerPool, store storage.Storage, pipeline pipeline) error {
+	peer.start()
+
+	queue.AddPeer(peer.bitfield)
+	peer.onHave = queue.PeerHas
+	defer func() { queue.RemovePeer(peer.bitfield) }()
+
+	pool.add(peer.addr)
+	defer pool.remove(peer.addr)
+
+	// exchange peers with the peer when the pool allows it
+	var pex pexSender
+	if pool != nil && peer.extended {
+		peer.onPEX = pool.learn
+
+		extensionPayload := peerwire.ExtensionPayload{
+			MessageID: 0,
+			Message: map[string]any{
+				"m": map[string]any{
+					"ut_pex": utPexID,
+				},
+			},
+		}
+		if err := sendExtension(peer, &extensionPayload); err != nil {
+			return err
+		}
+	}
+
+	depth := pipeline.min
+	for {
+		changed := queue.Changed()
+		if queue.Finished() {
+			return peer.setInterested(false)
+		}
+
+		// the first message waits for the peer's extension handshake
+		var pexDue <-chan time.Time
+		if peer.onPEX != nil && peer.extensions != nil {
+			if pex.due() {
+				if err := pex.send(peer, pool); err != nil {
+					return err
+				}
+			}
+			pexDue = time.After(time.Until(pex.last.Add(pexInterval)))
+		}
+
+		if err := peer.setInterested(queue.Wants(peer.bitfield.Has)); err != nil {
+			return fmt.Errorf("send interested: %w", err)
+		}
+
+		var piece *activePiece
+		ok := false
+		if !peer.peerChoking {
+			piece, ok = queue.Take(peer.bitfield.Has)
+		}
+		if !ok {
+			// wait until the peer unchokes us or announces a piece, or the
+			// queue changes
+			keepAliveDue := time.After(time.Until(peer.lastSent.Add(keepAliveInterval)))
+			select {
+			case m, open := <-peer.msgs:
+				if !open {
+					return peer.readErr
+				}
+				if err := peer.handle(&m); err != nil {
+					return err
+				}
+			case <-changed:
+			case <-pexDue:
+			case <-keepAliveDue:
+				if err := peer.send(&peerwire.PeerMessage{ID: peerwire.IDKeepAlive}); err != nil {
+					return fmt.Errorf("send keep-alive: %w", err)
+				}
+			}
+			continue
+		}
+
+		task := piece.task
+
+		// download piece
+		start := time.Now()
+		received, completed, err := fetchPiece(peer, queue, piece, depth)
+		if err != nil {
+			queue.Release(piece)
+			if errors.Is(err, errChoked) {
+				continue
+			}
+			return fmt.Errorf("piece %d: %w", task.pieceIndex, err)
+		}
+		if received > 0 {
+			depth = pipeline.depth(float64(received) / time.Since(start).Seconds())
+		}
+
+		if completed {
+			if _, err := finishPiece(torrentInfo, queue, piece, store, peer.addr); err != nil {
+				queue.Release(piece)
+				return err
+			}
+		}
+		queue.Release(piece)
+	}
+}
+
+// finishPiece verifies a completed piece from source and saves it to store,
+// reporting whether it was intact. A piece that fails verification goes back
+// to the queue.
+func finishPiece(torrentInfo *metainfo.TorrentInfo, queue *pieceQueue, piece *activePiece, store storage.Storage, source string) (bool, error) {
+	index := piece.task.pieceIndex
+	verified := torrentInfo.VerifyPiece(index, piece.data)
+	if verified {
+		if err := store.WritePiece(index, piece.data); err != nil {
+			queue.Finish(piece, false)
+			return false, fmt.Errorf("write piece %d: %w", index, err)
+		}
+		if err := store.MarkComplete(index); err != nil {
+			queue.logger.Printf("save resume state: %v", err)
+		}
+	}
+	queue.Finish(piece, verified)
+	queue.report.piece(index, verified, source)
+	return verified, nil
+}
+
+const blockSize = 16 * 1024 // 16KB
+
+// pipeline bounds the number of block requests kept outstanding per peer.
+type pipeline struct {
+	min, max int
+}
+
+// pipelineWindow is the transfer time the outstanding requests should cover,
+// roughly the bandwidth-delay product of the connection.
+const pipelineWindow = time.Second
+
+// depth returns how many block requests to keep outstanding for a peer
+// delivering rate bytes per second.
+func (p pipeline) depth(rate float64) int {
+	depth := int(rate * pipelineWindow.Seconds() / blockSize)
+	return max(p.min, min(depth, p.max))
+}
+
+// blockMessage builds a request or cancel message for a block of the piece
+// at index.
+func blockMessage(id byte, index, block, pieceSize int) (*peerwire.PeerMessage, error) {
+	payload, err := (&peerwire.RequestPayload{
+		Index:  uint32(index),
+		Begin:  uint32(block * blockSize),
+		Length: uint32(min(blockSize, pieceSize-block*blockSize)),
+	}).MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return &peerwire.PeerMessage{ID: id, Payload: payload}, nil
+}
+
+// fetchPiece downloads the missing blocks of piece from the peer, keeping up
+// to depth requests outstanding. Blocks are placed by their offset, so they
+// may arrive in any order. In endgame mode other workers fetch the same
+// piece, and requests for blocks they receive first are cancelled. A peer
+// that leaves our requests unanswered for the request timeout fails with
+// errRequestTimeout. It returns the number of bytes received and whether
+// this worker completed the piece.
+func fetchPiece(peer *Peer, queue *pieceQueue, piece *activePiece, depth int) (int, bool, error) {
+	index := piece.task.pieceIndex
+	pieceSize := len(piece.data)
+	requested := map[int]bool{}
+	received := 0
+	completed := false
+	lastBlock := time.Now()
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		changed := queue.Changed()
+		done, arrived := queue.blockState(piece, requested)
+		for _, block := range arrived {
+			delete(requested, block)
+			m, err := blockMessage(peerwire.IDCancel, index, block, pieceSize)
+			if err != nil {
+				return received, false, fmt.Errorf("marshal cancel: %w", err)
+			}
+			if err := peer.send(m); err != nil {
+				return received, false, fmt.Errorf("send cancel: %w", err)
+			}
+		}
+		if done {
+			return received, completed, nil
+		}
+
+		for _, block := range queue.nextBlocks(piece, requested, depth-len(requested)) {
+			m, err := blockMessage(peerwire.IDRequest, index, block, pieceSize)
+			if err != nil {
+				return received, false, fmt.Errorf("marshal request: %w", err)
+			}
+			if err := peer.send(m); err != nil {
+				return received, false, fmt.Errorf("send request: %w", err)
+			}
+			requested[block] = true
+		}
+
+		var timeout <-chan time.Time
+		if peer.requestTimeout > 0 && len(requested) > 0 {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(time.Until(lastBlock.Add(peer.requestTimeout)))
+			timeout = timer.C
+		}
+
+		select {
+		case m, open := <-peer.msgs:
+			if !open {
+				return received, false, fmt.Errorf("unmarshal piece: %w", peer.readErr)
+			}
+			if err := peer.handle(&m); err != nil {
+				return received, false, err
+			}
+			if peer.peerChoking {
+				// the peer discards our outstanding requests when choking
+				return received, false, errChoked
+			}
+			if m.ID != peerwire.IDPiece {
+				continue
+			}
+
+			var piecePayload peerwire.PiecePayload
+			if err := piecePayload.UnmarshalBinary(m.Payload); err != nil {
+				return received, false, fmt.Errorf("unmarshal piece: %w", err)
+			}
+			if int(piecePayload.Index) != index {
+				continue
+			}
+
+			delete(requested, int(piecePayload.Begin)/blockSize)
+			complete, err := queue.storeBlock(piece, int(piecePayload.Begin), piecePayload.Block)
+			if err != nil {
+				return received, false, err
+			}
+			received += len(piecePayload.Block)
+			peer.downloaded.add(len(piecePayload.Block))
+			completed = completed || complete
+			lastBlock = time.Now()
+		case <-changed:
+		case <-timeout:
+			return received, false, errRequestTimeout
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/metainfo"
+	"github.com/codecrafters-io/bittorrent-starter-go/storage"
+)
+
+// https://www.bittorrent.org/beps/bep_0019.html
+
+// webSeedURL returns the URL of a file of the torrent on the web seed. A
+// seed URL ending in a slash is a directory holding the content; otherwise it
+// names the file of a single-file torrent.
+func webSeedURL(seedURL string, torrentInfo *metainfo.TorrentInfo, entry metainfo.FileEntry) string {
+	if !torrentInfo.IsMultiFile() && !strings.HasSuffix(seedURL, "/") {
+		return seedURL
+	}
+
+	if !strings.HasSuffix(seedURL, "/") {
+		seedURL += "/"
+	}
+	segments := strings.Split(filepath.ToSlash(entry.Path), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return seedURL + strings.Join(segments, "/")
+}
+
+// readWebSeed reads length bytes of the content starting at offset from the
+// web seed, with a Range request per file the span touches.
+func readWebSeed(ctx context.Context, seedURL string, torrentInfo *metainfo.TorrentInfo, offset, length int) ([]byte, error) {
+	data := make([]byte, length)
+	for _, entry := range torrentInfo.FileEntries() {
+		begin := max(offset, entry.Offset)
+		end := min(offset+length, entry.Offset+entry.Length)
+		if begin >= end || entry.Padding {
+			continue
+		}
+
+		fileURL := webSeedURL(seedURL, torrentInfo, entry)
+		if err := readRange(ctx, fileURL, begin-entry.Offset, data[begin-offset:end-offset]); err != nil {
+			return nil, fmt.Errorf("%s: %w", fileURL, err)
+		}
+	}
+	return data, nil
+}
+
+// readRange fills p with the bytes of fileURL starting at offset.
+func readRange(ctx context.Context, fileURL string, offset int, p []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", fileURL, nil)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+len(p)-1))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("do request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// the server ignored the range and sends the whole file
+		if _, err := io.CopyN(io.Discard, resp.Body, int64(offset)); err != nil {
+			return fmt.Errorf("read body: %w", err)
+		}
+	default:
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	if _, err := io.ReadFull(resp.Body, p); err != nil {
+		return fmt.Errorf("read body: %w", err)
+	}
+	return nil
+}
+
+// downloadWebSeed downloads pieces from a web seed until the queue is
+// finished. A web seed has every piece, so it takes whatever the queue hands
+// out. It gives up on the first failed request or corrupt piece, handing the
+// piece back to the other workers, and when ctx is cancelled. Reading a piece
+// may take up to timeout.
+func downloadWebSeed(ctx context.Context, seedURL string, torrentInfo *metainfo.TorrentInfo, queue *pieceQueue, store storage.Storage, timeout time.Duration) error {
+	hasAll := func(int) bool { return true }
+	for {
+		changed := queue.Changed()
+		if queue.Finished() {
+			return nil
+		}
+
+		piece, ok := queue.Take(hasAll)
+		if !ok {
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			continue
+		}
+
+		index := piece.task.pieceIndex
+
+		readCtx, cancel := withTimeout(ctx, timeout)
+		data, err := readWebSeed(readCtx, seedURL, torrentInfo, index*torrentInfo.PieceLength, len(piece.data))
+		cancel()
+		if err != nil {
+			queue.Release(piece)
+			return fmt.Errorf("piece %d: %w", index, err)
+		}
+
+		completed := false
+		for begin := 0; begin < len(data); begin += blockSize {
+			complete, err := queue.storeBlock(piece, begin, data[begin:min(begin+blockSize, len(data))])
+			if err != nil {
+				queue.Release(piece)
+				return err
+			}
+			completed = completed || complete
+		}
+
+		if completed {
+			verified, err := finishPiece(torrentInfo, queue, piece, store, seedURL)
+			if err != nil {
+				queue.Release(piece)
+				return err
+			}
+			if !verified {
+				queue.Release(piece)
+				return fmt.Errorf("piece %d failed verification", index)
+			}
+		}
+		queue.Release(piece)
+	}
+}
@@ -0,0 +1,173 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/metainfo"
+	"github.com/codecrafters-io/bittorrent-starter-go/storage"
+)
+
+// webSeedTorrent returns random content of length bytes and the info of a
+// torrent whose files hold it, or of a single file
+// when files is nil.
+func webSeedTorrent(name string, length, pieceLength int, files []metainfo.TorrentFile) ([]byte, *metainfo.TorrentInfo) {
+	content := make([]byte, length)
+	rand.New(rand.NewSource(int64(length))).Read(content)
+
+	var pieces bytes.Buffer
+	for begin := 0; begin < length; begin += pieceLength {
+		hash := sha1.Sum(content[begin:min(begin+pieceLength, length)])
+		pieces.Write(hash[:])
+	}
+	torrentInfo := &metainfo.TorrentInfo{Name: name, PieceLength: pieceLength, Pieces: pieces.String(), Files: files}
+	if files == nil {
+		torrentInfo.Length = length
+	}
+	return content, torrentInfo
+}
+
+// serveWebSeed serves the content of torrentInfo laid out below a directory,
+// the way a web seed holds it, and records the paths and ranges requested.
+func serveWebSeed(t *testing.T, content []byte, torrentInfo *metainfo.TorrentInfo) (*httptest.Server, *[]string) {
+	dir := t.TempDir()
+	for _, entry := range torrentInfo.FileEntries() {
+		path := filepath.Join(dir, entry.Path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, content[entry.Offset:entry.Offset+entry.Length], 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var mu sync.Mutex
+	var requests []string
+	files := http.FileServer(http.Dir(dir))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.URL.Path+" "+r.Header.Get("Range"))
+		mu.Unlock()
+		files.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func downloadFromWebSeed(seedURL string, torrentInfo *metainfo.TorrentInfo) (*storage.Memory, *pieceQueue, error) {
+	var tasks []task
+	for i := 0; i < torrentInfo.PieceCount(); i++ {
+		tasks = append(tasks, task{pieceIndex: i})
+	}
+	queue := newPieceQueue(torrentInfo, tasks)
+	store := storage.NewMemory(torrentInfo)
+	err := downloadWebSeed(context.Background(), seedURL, torrentInfo, queue, store, time.Minute)
+	return store, queue, err
+}
+
+func TestWebSeedMultiFile(t *testing.T) {
+	files := []metainfo.TorrentFile{
+		{Length: 20000, Path: []string{"sub dir", "a b.bin"}},
+		{Length: 30000, Path: []string{"c.bin"}},
+	}
+	content, torrentInfo := webSeedTorrent("my files", 50000, 16384, files)
+	server, requests := serveWebSeed(t, content, torrentInfo)
+
+	store, queue, err := downloadFromWebSeed(server.URL+"/", torrentInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !queue.Finished() {
+		t.Fatal("queue not finished")
+	}
+	if !bytes.Equal(store.Bytes(), content) {
+		t.Fatal("downloaded content differs")
+	}
+
+	// the second piece spans both files
+	for _, want := range []string{
+		"/my files/sub dir/a b.bin bytes=16384-19999",
+		"/my files/c.bin bytes=0-12767",
+	} {
+		found := false
+		for _, request := range *requests {
+			found = found || request == want
+		}
+		if !found {
+			t.Errorf("no request %q in %q", want, *requests)
+		}
+	}
+}
+
+func TestWebSeedSingleFile(t *testing.T) {
+	content, torrentInfo := webSeedTorrent("f.bin", 40000, 16384, nil)
+
+	// a seed that ignores ranges still works, it just sends too much
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/data/f.bin" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	store, _, err := downloadFromWebSeed(server.URL+"/data/f.bin", torrentInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(store.Bytes(), content) {
+		t.Fatal("downloaded content differs")
+	}
+}
+
+func TestWebSeedBadPiece(t *testing.T) {
+	content, torrentInfo := webSeedTorrent("f.bin", 40000, 16384, nil)
+	corrupt := append([]byte(nil), content...)
+	corrupt[20000] ^= 1
+	server, _ := serveWebSeed(t, corrupt, torrentInfo)
+
+	store, queue, err := downloadFromWebSeed(server.URL+"/f.bin", torrentInfo)
+	if err == nil || !strings.Contains(err.Error(), "piece 1 failed verification") {
+		t.Fatalf("got error %v, want piece 1 to fail verification", err)
+	}
+	if store.Completed().Has(1) {
+		t.Error("corrupt piece stored")
+	}
+	if queue.Finished() {
+		t.Error("queue finished without the corrupt piece")
+	}
+}
+
+func TestWebSeedURL(t *testing.T) {
+	files := []metainfo.TorrentFile{{Length: 1, Path: []string{"sub dir", "a#b.bin"}}}
+	_, multiFile := webSeedTorrent("my files", 1, 16384, files)
+	_, singleFile := webSeedTorrent("f.bin", 1, 16384, nil)
+
+	tests := []struct {
+		seedURL     string
+		torrentInfo *metainfo.TorrentInfo
+		want        string
+	}{
+		{"http://seed/f.bin", singleFile, "http://seed/f.bin"},
+		{"http://seed/data/", singleFile, "http://seed/data/f.bin"},
+		{"http://seed/data", multiFile, "http://seed/data/my%20files/sub%20dir/a%23b.bin"},
+		{"http://seed/data/", multiFile, "http://seed/data/my%20files/sub%20dir/a%23b.bin"},
+	}
+	for _, test := range tests {
+		entry := test.torrentInfo.FileEntries()[0]
+		if got := webSeedURL(test.seedURL, test.torrentInfo, entry); got != test.want {
+			t.Errorf("webSeedURL(%q, %s) = %q, want %q", test.seedURL, entry.Path, got, test.want)
+		}
+	}
+}
@@ -1,20 +1,35 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
 	"log"
-	"math"
 	"net"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
-	"sync"
-
-	bencode "github.com/jackpal/bencode-go"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
+	"github.com/codecrafters-io/bittorrent-starter-go/client"
+	"github.com/codecrafters-io/bittorrent-starter-go/metainfo"
+	"github.com/codecrafters-io/bittorrent-starter-go/peerwire"
+	"github.com/codecrafters-io/bittorrent-starter-go/storage"
+	"github.com/codecrafters-io/bittorrent-starter-go/tracker"
 )
 
+// newClient returns a client logging to stderr, where the commands report
+// the failures they work around.
+func newClient(config client.Config) *client.Client {
+	config.Logger = log.Default()
+	return client.New(config)
+}
+
 func cmdDecode() {
 	bencodedValue := os.Args[2]
 
@@ -28,14 +43,17 @@ func cmdDecode() {
 }
 
 func cmdInfo() {
-	torrent, err := NewTorrent(os.Args[2])
+	torrent, err := metainfo.NewTorrent(os.Args[2])
 	if err != nil {
 		panic(err)
 	}
 
 	fmt.Printf("Tracker URL: %s\n", torrent.Announce)
-	fmt.Printf("Length: %d\n", torrent.Info.Length)
+	fmt.Printf("Length: %d\n", torrent.Info.TotalLength())
 	fmt.Printf("Info Hash: %x\n", torrent.Info.Hash())
+	if torrent.Info.IsV2() {
+		fmt.Printf("Info Hash v2: %x\n", torrent.Info.HashV2())
+	}
 	fmt.Printf("Piece Length: %d\n", torrent.Info.PieceLength)
 	fmt.Println("Piece Hashes:")
 	for _, hash := range torrent.Info.PieceHashes() {
@@ -43,13 +61,14 @@ func cmdInfo() {
 	}
 }
 
-func cmdPeers() {
-	torrent, err := NewTorrent(os.Args[2])
+func cmdPeers(ctx context.Context) {
+	torrent, err := metainfo.NewTorrent(os.Args[2])
 	if err != nil {
 		panic(err)
 	}
 
-	peers, err := torrent.Peers()
+	c := newClient(client.Config{})
+	peers, err := c.Peers(ctx, torrent.Trackers(), torrent.Info.Hash(), torrent.Info.TotalLength(), torrent.Info.Private == 1)
 	if err != nil {
 		panic(err)
 	}
@@ -59,41 +78,57 @@ func cmdPeers() {
 	}
 }
 
-func cmdHandshake() {
-	torrent, err := NewTorrent(os.Args[2])
+func cmdHandshake(ctx context.Context) {
+	torrent, err := metainfo.NewTorrent(os.Args[2])
 	if err != nil {
 		panic(err)
 	}
 
+	c := newClient(client.Config{})
 	peerAddr := os.Args[3]
-	conn, err := net.Dial("tcp", peerAddr)
+	conn, err := dialHandshake(ctx, peerAddr)
 	if err != nil {
 		panic(err)
 	}
 
 	defer conn.Close()
 
-	m := HandshakeMessage{
-		Protocol: "BitTorrent protocol",
+	m := peerwire.HandshakeMessage{
+		Protocol: peerwire.Protocol,
 		InfoHash: torrent.Info.Hash(),
-		PeerID:   peerID(),
+		PeerID:   c.PeerID(),
 	}
-	if err := marshalHandshakeMessage(conn, &m); err != nil {
+	if err := peerwire.MarshalHandshakeMessage(conn, &m); err != nil {
 		panic(err)
 	}
 
-	var response HandshakeMessage
-	if err := unmarshalHandshakeMessage(conn, &response); err != nil {
+	var response peerwire.HandshakeMessage
+	if err := peerwire.UnmarshalHandshakeMessage(conn, &response); err != nil {
 		panic(err)
 	}
 
 	fmt.Printf("Peer ID: %x\n", response.PeerID)
 }
 
-func cmdDownloadPiece() {
+// dialHandshake connects to the peer for a handshake by hand, bounding the
+// exchange by the client's default timeouts.
+func dialHandshake(ctx context.Context, peerAddr string) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: client.DefaultConnectTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", peerAddr)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.SetDeadline(time.Now().Add(client.DefaultHandshakeTimeout)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func cmdDownloadPiece(ctx context.Context) {
 	piecePath := os.Args[3]
 
-	torrent, err := NewTorrent(os.Args[4])
+	torrent, err := metainfo.NewTorrent(os.Args[4])
 	if err != nil {
 		panic(err)
 	}
@@ -103,7 +138,8 @@ func cmdDownloadPiece() {
 		panic(err)
 	}
 
-	peers, err := torrent.Peers()
+	c := newClient(client.Config{})
+	peers, err := c.Peers(ctx, torrent.Trackers(), torrent.Info.Hash(), torrent.Info.TotalLength(), torrent.Info.Private == 1)
 	if err != nil {
 		panic(err)
 	}
@@ -111,113 +147,130 @@ func cmdDownloadPiece() {
 		panic("no peers")
 	}
 
-	conn, _, err := dialPeer(peers[0], torrent.Info.Hash(), false)
+	peer, err := c.DialPeer(ctx, peers[0], torrent.Info.Hash())
 	if err != nil {
 		panic(err)
 	}
-	defer conn.Close()
+	defer peer.Close()
 
-	taskCh := make(chan task)
-	wg := sync.WaitGroup{}
-	go downloadPiece(conn, &torrent.Info, taskCh, &wg)
-	wg.Add(1)
-	taskCh <- task{
-		piecePath:  piecePath,
-		pieceIndex: pieceIndex,
-		pieceHash:  torrent.Info.PieceHashes()[pieceIndex],
-	}
-
-	wg.Wait()
-	close(taskCh)
-}
-
-func cmdDownload() {
-	piecePath := os.Args[3]
-
-	torrent, err := NewTorrent(os.Args[4])
+	out, err := storage.NewPieceFile(piecePath, &torrent.Info, pieceIndex)
 	if err != nil {
 		panic(err)
 	}
+	defer out.Close()
 
-	peers, err := torrent.Peers()
-	if err != nil {
+	if err := c.DownloadPiece(ctx, peer, &torrent.Info, pieceIndex, out); err != nil {
 		panic(err)
 	}
+}
 
-	taskCh := make(chan task)
-	wg := sync.WaitGroup{}
-	for i := 0; i < len(peers); i++ {
-		conn, _, err := dialPeer(peers[i], torrent.Info.Hash(), false)
-		if err != nil {
+// downloadFlags are the flags of the download commands.
+type downloadFlags struct {
+	output string
+	json   bool
+	source string
+}
+
+// parseDownloadFlags parses the arguments of a download command: -o with the
+// target path, --json to write events to stdout instead of drawing a progress
+// bar, and the torrent or magnet to download. Flags may follow the source.
+func parseDownloadFlags(name string) downloadFlags {
+	var f downloadFlags
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	flags.StringVar(&f.output, "o", "", "output `path`")
+	flags.BoolVar(&f.json, "json", false, "write progress events to stdout as newline delimited JSON")
+
+	var sources []string
+	args := os.Args[2:]
+	for {
+		if err := flags.Parse(args); err != nil {
 			panic(err)
 		}
-		defer conn.Close()
-
-		go downloadPiece(conn, &torrent.Info, taskCh, &wg)
+		if flags.NArg() == 0 {
+			break
+		}
+		sources = append(sources, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+	if f.output == "" || len(sources) != 1 {
+		fmt.Fprintf(os.Stderr, "usage: mybittorrent %s -o <path> [--json] <source>\n", name)
+		os.Exit(2)
 	}
+	f.source = sources[0]
+	return f
+}
 
-	size := torrent.Info.Length
-	pieceSize := torrent.Info.PieceLength
-	pieceCount := int(math.Ceil(float64(size) / float64(pieceSize)))
-	wg.Add(pieceCount)
-	pieceHashes := torrent.Info.PieceHashes()
-	for i := 0; i < pieceCount; i++ {
-		taskCh <- task{
-			piecePath:  fmt.Sprintf("%s-%d", piecePath, i),
-			pieceIndex: i,
-			pieceHash:  pieceHashes[i],
-		}
+func (f downloadFlags) config() client.Config {
+	var config client.Config
+	if f.json {
+		config.OnEvent = jsonEvents(os.Stdout)
 	}
+	return config
+}
 
-	wg.Wait()
-	close(taskCh)
+func cmdDownload(ctx context.Context) {
+	flags := parseDownloadFlags("download")
 
-	// merge pieces to target file
-	targetFile, err := os.Create(piecePath)
+	torrent, err := metainfo.NewTorrent(flags.source)
 	if err != nil {
 		panic(err)
 	}
-	defer targetFile.Close()
 
-	for i := 0; i < pieceCount; i++ {
-		pieceFile, err := os.Open(fmt.Sprintf("%s-%d", piecePath, i))
-		if err != nil {
-			panic(err)
-		}
+	c := newClient(flags.config())
+	d, err := c.AddTorrent(torrent, flags.output)
+	if err != nil {
+		panic(err)
+	}
+	waitDownload(ctx, d, !flags.json)
+}
 
-		if _, err := io.Copy(targetFile, pieceFile); err != nil {
-			panic(err)
-		}
+// waitDownload waits for the download to finish, drawing a progress bar if
+// asked to. An interrupt cancels it, leaving the pieces verified so far to be
+// resumed by the next run.
+func waitDownload(ctx context.Context, d *client.Download, bar bool) {
+	stop := context.AfterFunc(ctx, d.Cancel)
+	defer stop()
 
-		pieceFile.Close()
+	stopProgress := func() {}
+	if bar {
+		stopProgress = showProgress(d)
 	}
-
-	for i := 0; i < pieceCount; i++ {
-		os.Remove(fmt.Sprintf("%s-%d", piecePath, i))
+	err := d.Wait()
+	stopProgress()
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintln(os.Stderr, "interrupted, run the command again to resume")
+		os.Exit(130)
+	}
+	if err != nil {
+		panic(err)
 	}
 }
 
 func cmdMagnetParse() {
 	magnetURL := os.Args[2]
 
-	m, err := NewMagnet(magnetURL)
+	m, err := metainfo.NewMagnet(magnetURL)
 	if err != nil {
 		panic(err)
 	}
 
 	fmt.Printf("Tracker URL: %s\n", m.TrackerURL)
 	fmt.Printf("Info Hash: %x\n", m.InfoHash)
+	if m.InfoHashV2 != nil {
+		fmt.Printf("Info Hash v2: %x\n", m.InfoHashV2)
+	}
 }
 
-func cmdMagnetHandshake() {
+func cmdMagnetHandshake(ctx context.Context) {
 	magnetURL := os.Args[2]
 
-	magnet, err := NewMagnet(magnetURL)
+	magnet, err := metainfo.NewMagnet(magnetURL)
 	if err != nil {
 		panic(err)
 	}
 
-	peers, err := magnet.Peers()
+	c := newClient(client.Config{})
+	peers, err := c.Peers(ctx, magnet.Trackers(), magnet.InfoHash, 1, false)
 	if err != nil {
 		panic(err)
 	}
@@ -226,24 +279,24 @@ func cmdMagnetHandshake() {
 		panic("no peers")
 	}
 
-	conn, err := net.Dial("tcp", peers[0])
+	conn, err := dialHandshake(ctx, peers[0])
 	if err != nil {
 		panic(err)
 	}
 
 	defer conn.Close()
 
-	handshake := HandshakeMessage{
-		Protocol: "BitTorrent protocol",
+	handshake := peerwire.HandshakeMessage{
+		Protocol: peerwire.Protocol,
 		InfoHash: magnet.InfoHash,
-		PeerID:   peerID(),
+		PeerID:   c.PeerID(),
 	}
 	handshake.SetExtension()
-	if err := marshalHandshakeMessage(conn, &handshake); err != nil {
+	if err := peerwire.MarshalHandshakeMessage(conn, &handshake); err != nil {
 		panic(err)
 	}
 
-	if err := unmarshalHandshakeMessage(conn, &handshake); err != nil {
+	if err := peerwire.UnmarshalHandshakeMessage(conn, &handshake); err != nil {
 		panic(err)
 	}
 
@@ -254,17 +307,8 @@ func cmdMagnetHandshake() {
 		return
 	}
 
-	// read bitfield
-	var m PeerMessage
-	if err := unmarshalPeerMessage(conn, &m); err != nil {
-		panic(err)
-	}
-	if m.ID != IDBitfield {
-		panic("expect bitfield")
-	}
-
 	// extension handshake
-	extensionPayload := ExtensionPayload{
+	extensionPayload := peerwire.ExtensionPayload{
 		MessageID: 0,
 		Message: map[string]any{
 			"m": map[string]any{
@@ -276,54 +320,61 @@ func cmdMagnetHandshake() {
 	if err != nil {
 		panic(err)
 	}
-	m = PeerMessage{
-		ID:      IDExtension,
+	m := peerwire.PeerMessage{
+		ID:      peerwire.IDExtension,
 		Payload: payload,
 	}
-	if err := marshalPeerMessage(conn, &m); err != nil {
-		panic(err)
-	}
-	if err := unmarshalPeerMessage(conn, &m); err != nil {
+	if err := peerwire.MarshalPeerMessage(conn, &m); err != nil {
 		panic(err)
 	}
-	if m.ID != IDExtension {
-		panic("expect extension")
-	}
 
-	if err := extensionPayload.UnmarshalBinary(m.Payload); err != nil {
-		panic(err)
+	// skip the bitfield, which peers without pieces may leave out, and
+	// anything else sent before the peer's extension handshake
+	for {
+		if err := peerwire.UnmarshalPeerMessage(conn, &m); err != nil {
+			panic(err)
+		}
+		if m.ID != peerwire.IDExtension {
+			continue
+		}
+		if err := extensionPayload.UnmarshalBinary(m.Payload); err != nil {
+			panic(err)
+		}
+		if extensionPayload.MessageID == 0 {
+			break
+		}
 	}
 
 	peerExtID := extensionPayload.Message.(map[string]any)["m"].(map[string]any)["ut_metadata"]
 	fmt.Printf("Peer Metadata Extension ID: %v\n", peerExtID)
 }
 
-func cmdMagnetInfo() {
+func cmdMagnetInfo(ctx context.Context) {
 	magnetURL := os.Args[2]
 
-	magnet, err := NewMagnet(magnetURL)
+	magnet, err := metainfo.NewMagnet(magnetURL)
 	if err != nil {
 		panic(err)
 	}
 
-	peers, err := magnet.Peers()
+	c := newClient(client.Config{})
+	peers, err := c.Peers(ctx, magnet.Trackers(), magnet.InfoHash, 1, false)
 	if err != nil {
 		panic(err)
 	}
 
-	if len(peers) == 0 {
-		panic("no peers")
-	}
-
-	conn, torrentInfo, err := dialPeer(peers[0], magnet.InfoHash, true)
+	peer, torrentInfo, err := c.DialMetadataPeer(ctx, peers, magnet.InfoHash)
 	if err != nil {
 		panic(err)
 	}
-	conn.Close()
+	peer.Close()
 
 	fmt.Printf("Tracker URL: %s\n", magnet.TrackerURL)
-	fmt.Printf("Length: %d\n", torrentInfo.Length)
+	fmt.Printf("Length: %d\n", torrentInfo.TotalLength())
 	fmt.Printf("Info Hash: %x\n", torrentInfo.Hash())
+	if torrentInfo.IsV2() {
+		fmt.Printf("Info Hash v2: %x\n", torrentInfo.HashV2())
+	}
 	fmt.Printf("Piece Length: %d\n", torrentInfo.PieceLength)
 	fmt.Println("Piece Hashes:")
 	for _, hash := range torrentInfo.PieceHashes() {
@@ -331,10 +382,10 @@ func cmdMagnetInfo() {
 	}
 }
 
-func cmdMagnetDownloadPiece() {
+func cmdMagnetDownloadPiece(ctx context.Context) {
 	piecePath := os.Args[3]
 
-	magnet, err := NewMagnet(os.Args[4])
+	magnet, err := metainfo.NewMagnet(os.Args[4])
 	if err != nil {
 		panic(err)
 	}
@@ -344,128 +395,288 @@ func cmdMagnetDownloadPiece() {
 		panic(err)
 	}
 
-	peers, err := magnet.Peers()
+	c := newClient(client.Config{})
+	peers, err := c.Peers(ctx, magnet.Trackers(), magnet.InfoHash, 1, false)
 	if err != nil {
 		panic(err)
 	}
-	if len(peers) == 0 {
-		panic("no peers")
+	peer, torrentInfo, err := c.DialMetadataPeer(ctx, peers, magnet.InfoHash)
+	if err != nil {
+		panic(err)
 	}
+	defer peer.Close()
 
-	conn, torrentInfo, err := dialPeer(peers[0], magnet.InfoHash, true)
+	out, err := storage.NewPieceFile(piecePath, torrentInfo, pieceIndex)
 	if err != nil {
 		panic(err)
 	}
-	defer conn.Close()
+	defer out.Close()
 
-	taskCh := make(chan task)
-	wg := sync.WaitGroup{}
-	go downloadPiece(conn, torrentInfo, taskCh, &wg)
-	wg.Add(1)
-	taskCh <- task{
-		piecePath:  piecePath,
-		pieceIndex: pieceIndex,
-		pieceHash:  torrentInfo.PieceHashes()[pieceIndex],
+	if err := c.DownloadPiece(ctx, peer, torrentInfo, pieceIndex, out); err != nil {
+		panic(err)
 	}
-
-	wg.Wait()
-	close(taskCh)
 }
 
-func cmdMagnetDownload() {
-	piecePath := os.Args[3]
+func cmdMagnetDownload(ctx context.Context) {
+	flags := parseDownloadFlags("magnet_download")
 
-	magnet, err := NewMagnet(os.Args[4])
+	magnet, err := metainfo.NewMagnet(flags.source)
 	if err != nil {
 		panic(err)
 	}
 
-	peers, err := magnet.Peers()
+	c := newClient(flags.config())
+	d, err := c.AddMagnet(magnet, flags.output)
 	if err != nil {
 		panic(err)
 	}
+	waitDownload(ctx, d, !flags.json)
+}
 
-	taskCh := make(chan task)
-	wg := sync.WaitGroup{}
-	var torrentInfo *TorrentInfo
-	for i := 0; i < len(peers); i++ {
-		var conn net.Conn
-		conn, torrentInfo, err = dialPeer(peers[i], magnet.InfoHash, true)
-		if err != nil {
-			panic(err)
-		}
-		defer conn.Close()
+func cmdMagnetToTorrent(ctx context.Context) {
+	torrentPath := os.Args[3]
 
-		go downloadPiece(conn, torrentInfo, taskCh, &wg)
+	magnet, err := metainfo.NewMagnet(os.Args[4])
+	if err != nil {
+		panic(err)
 	}
 
-	size := torrentInfo.Length
-	pieceSize := torrentInfo.PieceLength
-	pieceCount := int(math.Ceil(float64(size) / float64(pieceSize)))
-	wg.Add(pieceCount)
-	pieceHashes := torrentInfo.PieceHashes()
-	for i := 0; i < pieceCount; i++ {
-		taskCh <- task{
-			piecePath:  fmt.Sprintf("%s-%d", piecePath, i),
-			pieceIndex: i,
-			pieceHash:  pieceHashes[i],
-		}
+	c := newClient(client.Config{})
+	peers, err := c.Peers(ctx, magnet.Trackers(), magnet.InfoHash, 1, false)
+	if err != nil {
+		panic(err)
 	}
 
-	wg.Wait()
-	close(taskCh)
+	peer, torrentInfo, err := c.DialMetadataPeer(ctx, peers, magnet.InfoHash)
+	if err != nil {
+		panic(err)
+	}
+	peer.Close()
 
-	// merge pieces to target file
-	targetFile, err := os.Create(piecePath)
+	data, err := magnet.Torrent(torrentInfo).MarshalBinary()
 	if err != nil {
 		panic(err)
 	}
-	defer targetFile.Close()
+	if err := os.WriteFile(torrentPath, data, 0o644); err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("Saved %s to %s\n", torrentInfo.Name, torrentPath)
+}
 
-	for i := 0; i < pieceCount; i++ {
-		pieceFile, err := os.Open(fmt.Sprintf("%s-%d", piecePath, i))
+// scrapeOutput is the JSON printed by the scrape command, with a result or
+// an error for every tracker.
+type scrapeOutput struct {
+	InfoHash string              `json:"info_hash"`
+	Trackers []scrapeTrackerInfo `json:"trackers"`
+}
+
+type scrapeTrackerInfo struct {
+	URL string `json:"url"`
+	*tracker.ScrapeResult
+	Error string `json:"error,omitempty"`
+}
+
+func cmdScrape(ctx context.Context) {
+	var infoHash []byte
+	var tiers [][]string
+	if strings.HasPrefix(os.Args[2], "magnet:") {
+		magnet, err := metainfo.NewMagnet(os.Args[2])
 		if err != nil {
 			panic(err)
 		}
-
-		if _, err := io.Copy(targetFile, pieceFile); err != nil {
+		infoHash, tiers = magnet.InfoHash, magnet.Trackers()
+	} else {
+		torrent, err := metainfo.NewTorrent(os.Args[2])
+		if err != nil {
 			panic(err)
 		}
+		infoHash, tiers = torrent.Info.Hash(), torrent.Trackers()
+	}
+
+	output := scrapeOutput{InfoHash: fmt.Sprintf("%x", infoHash), Trackers: []scrapeTrackerInfo{}}
+	for _, tier := range tiers {
+		for _, trackerURL := range tier {
+			info := scrapeTrackerInfo{URL: trackerURL}
+			trackerCtx, cancel := context.WithTimeout(ctx, tracker.DefaultTimeout)
+			results, err := tracker.Scrape(trackerCtx, trackerURL, [][]byte{infoHash})
+			cancel()
+			if err != nil {
+				info.Error = err.Error()
+			} else if result, ok := results[string(infoHash)]; ok {
+				info.ScrapeResult = &result
+			} else {
+				info.Error = "torrent not known to tracker"
+			}
+			output.Trackers = append(output.Trackers, info)
+		}
+	}
 
-		pieceFile.Close()
+	jsonOutput, err := json.MarshalIndent(output, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(string(jsonOutput))
+}
+
+// stringList is a flag that can be repeated.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func cmdCreate() {
+	contentPath := os.Args[2]
+
+	flags := flag.NewFlagSet("create", flag.ExitOnError)
+	output := flags.String("o", "", "output .torrent `file`")
+	var trackers, webSeeds stringList
+	flags.Var(&trackers, "tracker", "tracker `url`, repeat for more tiers; comma separated urls share a tier")
+	flags.Var(&webSeeds, "web-seed", "web seed `url`, may be repeated")
+	pieceLength := flags.Int("piece-length", 0, "piece length in bytes, chosen from the content size when 0")
+	private := flags.Bool("private", false, "set the private flag")
+	comment := flags.String("comment", "", "comment")
+	createdBy := flags.String("created-by", "mybittorrent", "created by")
+	if err := flags.Parse(os.Args[3:]); err != nil {
+		panic(err)
+	}
+	if *output == "" {
+		*output = strings.TrimSuffix(contentPath, string(filepath.Separator)) + ".torrent"
+	}
+
+	torrentInfo, err := metainfo.NewTorrentInfoFromPath(contentPath, *pieceLength)
+	if err != nil {
+		panic(err)
+	}
+	if *private {
+		torrentInfo.Private = 1
 	}
 
-	for i := 0; i < pieceCount; i++ {
-		os.Remove(fmt.Sprintf("%s-%d", piecePath, i))
+	torrent := &metainfo.Torrent{
+		Info:         *torrentInfo,
+		Comment:      *comment,
+		CreatedBy:    *createdBy,
+		CreationDate: int(time.Now().Unix()),
+		URLList:      webSeeds,
+	}
+	for _, tier := range trackers {
+		torrent.AnnounceList = append(torrent.AnnounceList, strings.Split(tier, ","))
+	}
+	if len(torrent.AnnounceList) > 0 {
+		torrent.Announce = torrent.AnnounceList[0][0]
+	}
+	if len(torrent.AnnounceList) == 1 && len(torrent.AnnounceList[0]) == 1 {
+		torrent.AnnounceList = nil
+	}
+
+	data, err := torrent.MarshalBinary()
+	if err != nil {
+		panic(err)
+	}
+	if err := os.WriteFile(*output, data, 0o644); err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("Info Hash: %x\n", torrent.Info.Hash())
+	fmt.Printf("Piece Length: %d\n", torrent.Info.PieceLength)
+	fmt.Printf("Pieces: %d\n", torrent.Info.PieceCount())
+	fmt.Printf("Saved %s\n", *output)
+}
+
+func cmdSeed(ctx context.Context) {
+	torrent, err := metainfo.NewTorrent(os.Args[2])
+	if err != nil {
+		panic(err)
+	}
+
+	content, err := metainfo.NewContentReader(os.Args[3], &torrent.Info)
+	if err != nil {
+		panic(err)
+	}
+	defer content.Close()
+
+	bitfield := storage.CheckPieces(content, &torrent.Info)
+	if bitfield.Count() != torrent.Info.PieceCount() {
+		panic(fmt.Sprintf("content has %d of %d pieces", bitfield.Count(), torrent.Info.PieceCount()))
+	}
+
+	c := newClient(client.Config{})
+	seeder, err := c.NewSeeder(fmt.Sprintf(":%d", c.Port()))
+	if err != nil {
+		panic(err)
+	}
+	defer seeder.Close()
+	seeder.Add(&torrent.Info, content, bitfield)
+
+	infoHash := torrent.Info.Hash()
+	announcer := c.NewAnnouncer(torrent.Trackers(), infoHash, seeder.Stats(infoHash))
+	go func() {
+		if _, err := announcer.Start(ctx); err != nil {
+			log.Printf("announce: %v", err)
+		}
+		if torrent.Info.Private != 1 {
+			if err := c.AnnounceDHT(ctx, infoHash); err != nil {
+				log.Printf("dht announce: %v", err)
+			}
+		}
+	}()
+
+	// tell the trackers we are leaving on ctrl-c
+	context.AfterFunc(ctx, func() { seeder.Close() })
+
+	fmt.Printf("Seeding %s on %s\n", torrent.Info.Name, seeder.Addr())
+	err = seeder.Serve()
+	announcer.Stop()
+	if err != nil {
+		panic(err)
 	}
 }
 
 func main() {
 	command := os.Args[1]
 
+	// the first interrupt cancels the command, a second one kills it
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	context.AfterFunc(ctx, stop)
+
 	switch command {
 	case "decode":
 		cmdDecode()
 	case "info":
 		cmdInfo()
 	case "peers":
-		cmdPeers()
+		cmdPeers(ctx)
 	case "handshake":
-		cmdHandshake()
+		cmdHandshake(ctx)
 	case "download_piece":
-		cmdDownloadPiece()
+		cmdDownloadPiece(ctx)
 	case "download":
-		cmdDownload()
+		cmdDownload(ctx)
 	case "magnet_parse":
 		cmdMagnetParse()
 	case "magnet_handshake":
-		cmdMagnetHandshake()
+		cmdMagnetHandshake(ctx)
 	case "magnet_info":
-		cmdMagnetInfo()
+		cmdMagnetInfo(ctx)
 	case "magnet_download_piece":
-		cmdMagnetDownloadPiece()
+		cmdMagnetDownloadPiece(ctx)
 	case "magnet_download":
-		cmdMagnetDownload()
+		cmdMagnetDownload(ctx)
+	case "magnet_to_torrent":
+		cmdMagnetToTorrent(ctx)
+	case "scrape":
+		cmdScrape(ctx)
+	case "create":
+		cmdCreate()
+	case "seed":
+		cmdSeed(ctx)
 	default:
 		fmt.Println("Unknown command: " + command)
 		os.Exit(1)
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/client"
+)
+
+// jsonEvent is a download event as written by --json, one per line.
+type jsonEvent struct {
+	Type       client.EventType `json:"type"`
+	Time       time.Time        `json:"time"`
+	InfoHash   string           `json:"info_hash"`
+	Piece      *int             `json:"piece,omitempty"`
+	Peer       string           `json:"peer,omitempty"`
+	Error      string           `json:"error,omitempty"`
+	Pieces     int              `json:"pieces"`
+	PieceCount int              `json:"piece_count"`
+	Downloaded int              `json:"downloaded"`
+	Uploaded   int              `json:"uploaded"`
+	Left       int              `json:"left"`
+	Peers      int              `json:"peers"`
+}
+
+// jsonEvents returns an event handler writing newline delimited JSON to w.
+func jsonEvents(w io.Writer) func(client.Event) {
+	var mu sync.Mutex
+	encoder := json.NewEncoder(w)
+	return func(event client.Event) {
+		line := jsonEvent{
+			Type:       event.Type,
+			Time:       event.Time,
+			InfoHash:   hex.EncodeToString(event.InfoHash),
+			Peer:       event.Source,
+			Pieces:     event.Progress.Pieces,
+			PieceCount: event.Progress.PieceCount,
+			Downloaded: event.Progress.Downloaded,
+			Uploaded:   event.Progress.Uploaded,
+			Left:       event.Progress.Left,
+			Peers:      len(event.Progress.Peers),
+		}
+		if event.Type == client.EventPieceVerified || event.Type == client.EventHashFailed {
+			line.Piece = &event.Piece
+		}
+		if event.Err != nil {
+			line.Error = event.Err.Error()
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		encoder.Encode(line)
+	}
+}
+
+// isTerminal reports whether f is a terminal rather than a file or pipe.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+const progressInterval = 500 * time.Millisecond
+
+// showProgress draws a progress bar for d on stderr until the returned
+// function is called. It draws nothing when stderr is not a terminal.
+func showProgress(d *client.Download) (stop func()) {
+	if !isTerminal(os.Stderr) {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			fmt.Fprintf(os.Stderr, "\r%s\x1b[K", formatProgress(d.Progress()))
+			select {
+			case <-ticker.C:
+			case <-done:
+				fmt.Fprintf(os.Stderr, "\r%s\x1b[K\n", formatProgress(d.Progress()))
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+const progressBarWidth = 30
+
+// formatProgress renders progress as a single line: a bar, the pieces
+// done, the download rate, the time left and the peers.
+func formatProgress(progress client.Progress) string {
+	if progress.PieceCount == 0 {
+		return fmt.Sprintf("fetching metadata  %d peers", len(progress.Peers))
+	}
+
+	done := float64(progress.Pieces) / float64(progress.PieceCount)
+	filled := int(done * progressBarWidth)
+	bar := strings.Repeat("#", filled) + strings.Repeat("-", progressBarWidth-filled)
+
+	eta := "--:--"
+	if progress.ETA > 0 {
+		eta = formatDuration(progress.ETA)
+	}
+	return fmt.Sprintf("[%s] %5.1f%%  %d/%d pieces  %s/s  ETA %s  %d peers",
+		bar, done*100, progress.Pieces, progress.PieceCount,
+		formatBytes(progress.Rate), eta, len(progress.Peers))
+}
+
+func formatBytes(n float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB"}
+	unit := 0
+	for n >= 1024 && unit < len(units)-1 {
+		n /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%.0f %s", n, units[unit])
+	}
+	return fmt.Sprintf("%.1f %s", n, units[unit])
+}
+
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Second)
+	hours, minutes, seconds := int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60
+	if hours > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", hours, minutes, seconds)
+	}
+	return fmt.Sprintf("%d:%02d", minutes, seconds)
+}
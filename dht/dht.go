@@ -0,0 +1,638 @@
+// Package dht implements a node of the mainline DHT, which finds peers for
+// torrents without a tracker.
+package dht
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
+)
+
+// https://www.bittorrent.org/beps/bep_0005.html
+
+// BootstrapNodes are contacted when the routing table is empty.
+var BootstrapNodes = []string{
+	"router.bittorrent.com:6881",
+	"dht.transmissionbt.com:6881",
+	"router.utorrent.com:6881",
+}
+
+// queryTimeout bounds how long a single KRPC query waits for a response.
+var queryTimeout = 2 * time.Second
+
+const (
+	bucketSize     = 8
+	alpha          = 3
+	nodeStaleAfter = 15 * time.Minute
+	tokenRotation  = 5 * time.Minute
+	peerTTL        = 30 * time.Minute
+)
+
+type remoteNode struct {
+	id       string
+	addr     *net.UDPAddr
+	lastSeen time.Time
+}
+
+// distanceLess reports whether a is closer to target than b.
+func distanceLess(target, a, b string) bool {
+	for i := 0; i < len(target); i++ {
+		da, db := a[i]^target[i], b[i]^target[i]
+		if da != db {
+			return da < db
+		}
+	}
+	return false
+}
+
+func commonPrefixLen(a, b string) int {
+	for i := 0; i < len(a); i++ {
+		if x := a[i] ^ b[i]; x != 0 {
+			return i*8 + bits.LeadingZeros8(x)
+		}
+	}
+	return len(a) * 8
+}
+
+// routingTable keeps up to bucketSize nodes per bucket, where bucket i
+// holds the nodes sharing exactly i leading bits with our own id.
+type routingTable struct {
+	mu      sync.Mutex
+	self    string
+	buckets [160][]*remoteNode
+}
+
+func (t *routingTable) insert(id string, addr *net.UDPAddr) {
+	if len(id) != 20 || id == t.self {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	i := commonPrefixLen(t.self, id)
+	bucket := t.buckets[i]
+	for j, n := range bucket {
+		if n.id == id {
+			n.addr = addr
+			n.lastSeen = time.Now()
+			t.buckets[i] = append(append(bucket[:j:j], bucket[j+1:]...), n)
+			return
+		}
+	}
+
+	node := &remoteNode{id: id, addr: addr, lastSeen: time.Now()}
+	if len(bucket) < bucketSize {
+		t.buckets[i] = append(bucket, node)
+		return
+	}
+
+	// replace the least recently seen node only once it has gone stale
+	if time.Since(bucket[0].lastSeen) > nodeStaleAfter {
+		t.buckets[i] = append(bucket[1:len(bucket):len(bucket)], node)
+	}
+}
+
+func (t *routingTable) remove(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	i := commonPrefixLen(t.self, id)
+	if i >= len(t.buckets) {
+		return
+	}
+	for j, n := range t.buckets[i] {
+		if n.id == id {
+			t.buckets[i] = append(t.buckets[i][:j:j], t.buckets[i][j+1:]...)
+			return
+		}
+	}
+}
+
+func (t *routingTable) closest(target string, k int) []*remoteNode {
+	t.mu.Lock()
+	var nodes []*remoteNode
+	for _, bucket := range t.buckets {
+		nodes = append(nodes, bucket...)
+	}
+	t.mu.Unlock()
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return distanceLess(target, nodes[i].id, nodes[j].id)
+	})
+	if len(nodes) > k {
+		nodes = nodes[:k]
+	}
+	return nodes
+}
+
+func (t *routingTable) size() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := 0
+	for _, bucket := range t.buckets {
+		n += len(bucket)
+	}
+	return n
+}
+
+// DHT is a mainline DHT node speaking KRPC over UDP.
+type DHT struct {
+	id    string
+	conn  net.PacketConn
+	table *routingTable
+
+	mu      sync.Mutex
+	nextTID uint16
+	pending map[string]chan map[string]any
+	peers   map[string]map[string]time.Time
+	secrets [2][]byte
+	rotated time.Time
+}
+
+// New starts a DHT node listening on the UDP address addr.
+func New(addr string) (*DHT, error) {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+
+	id := make([]byte, 20)
+	if _, err := rand.Read(id); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("generate node id: %w", err)
+	}
+
+	d := &DHT{
+		id:      string(id),
+		conn:    conn,
+		table:   &routingTable{self: string(id)},
+		pending: map[string]chan map[string]any{},
+		peers:   map[string]map[string]time.Time{},
+	}
+	d.rotateSecret()
+	go d.serve()
+
+	return d, nil
+}
+
+func (d *DHT) Addr() net.Addr {
+	return d.conn.LocalAddr()
+}
+
+func (d *DHT) Close() error {
+	return d.conn.Close()
+}
+
+func (d *DHT) serve() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, addr, err := d.conn.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			continue
+		}
+
+		decoded, err := bencode.Decode(bytes.NewReader(buf[:n]))
+		if err != nil {
+			continue
+		}
+		msg, ok := decoded.(map[string]any)
+		if !ok {
+			continue
+		}
+		udpAddr, ok := addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+
+		switch msg["y"] {
+		case "q":
+			d.handleQuery(udpAddr, msg)
+		case "r", "e":
+			tid, _ := msg["t"].(string)
+			d.mu.Lock()
+			ch, ok := d.pending[tid]
+			delete(d.pending, tid)
+			d.mu.Unlock()
+			if ok {
+				ch <- msg
+			}
+		}
+	}
+}
+
+func (d *DHT) send(addr *net.UDPAddr, msg map[string]any) error {
+	buf := new(bytes.Buffer)
+	if err := bencode.Marshal(buf, msg); err != nil {
+		return fmt.Errorf("marshal: %w", err)
+	}
+	if _, err := d.conn.WriteTo(buf.Bytes(), addr); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	return nil
+}
+
+// query sends a KRPC query and waits for the matching response, returning
+// its "r" dictionary. Responding nodes are added to the routing table.
+func (d *DHT) query(ctx context.Context, addr *net.UDPAddr, method string, args map[string]any) (map[string]any, error) {
+	d.mu.Lock()
+	d.nextTID++
+	tid := string(binary.BigEndian.AppendUint16(nil, d.nextTID))
+	ch := make(chan map[string]any, 1)
+	d.pending[tid] = ch
+	d.mu.Unlock()
+
+	args["id"] = d.id
+	err := d.send(addr, map[string]any{
+		"t": tid,
+		"y": "q",
+		"q": method,
+		"a": args,
+	})
+	if err != nil {
+		d.mu.Lock()
+		delete(d.pending, tid)
+		d.mu.Unlock()
+		return nil, err
+	}
+
+	select {
+	case msg := <-ch:
+		if msg["y"] == "e" {
+			return nil, fmt.Errorf("%s: error %v", method, msg["e"])
+		}
+		r, ok := msg["r"].(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s: missing response", method)
+		}
+		id, _ := r["id"].(string)
+		d.table.insert(id, addr)
+		return r, nil
+	case <-time.After(queryTimeout):
+		d.mu.Lock()
+		delete(d.pending, tid)
+		d.mu.Unlock()
+		return nil, fmt.Errorf("%s: timeout", method)
+	case <-ctx.Done():
+		d.mu.Lock()
+		delete(d.pending, tid)
+		d.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+func (d *DHT) handleQuery(addr *net.UDPAddr, msg map[string]any) {
+	tid, _ := msg["t"].(string)
+	method, _ := msg["q"].(string)
+	args, _ := msg["a"].(map[string]any)
+	id, _ := args["id"].(string)
+	if len(id) != 20 {
+		d.sendError(addr, tid, 203, "invalid id")
+		return
+	}
+	d.table.insert(id, addr)
+
+	r := map[string]any{"id": d.id}
+	switch method {
+	case "ping":
+	case "find_node":
+		target, _ := args["target"].(string)
+		if len(target) != 20 {
+			d.sendError(addr, tid, 203, "invalid target")
+			return
+		}
+		r["nodes"] = d.compactNodes(target)
+	case "get_peers":
+		infoHash, _ := args["info_hash"].(string)
+		if len(infoHash) != 20 {
+			d.sendError(addr, tid, 203, "invalid info_hash")
+			return
+		}
+		r["token"] = d.token(addr.IP, 0)
+		if values := d.storedPeers(infoHash); len(values) > 0 {
+			r["values"] = values
+		} else {
+			r["nodes"] = d.compactNodes(infoHash)
+		}
+	case "announce_peer":
+		infoHash, _ := args["info_hash"].(string)
+		token, _ := args["token"].(string)
+		if len(infoHash) != 20 || !d.validToken(addr.IP, token) {
+			d.sendError(addr, tid, 203, "invalid token")
+			return
+		}
+		port, _ := args["port"].(int64)
+		if implied, _ := args["implied_port"].(int64); implied != 0 {
+			port = int64(addr.Port)
+		}
+		if port <= 0 || port > 65535 {
+			d.sendError(addr, tid, 203, "invalid port")
+			return
+		}
+		d.storePeer(infoHash, net.JoinHostPort(addr.IP.String(), strconv.Itoa(int(port))))
+	default:
+		d.sendError(addr, tid, 204, "method unknown")
+		return
+	}
+
+	_ = d.send(addr, map[string]any{"t": tid, "y": "r", "r": r})
+}
+
+func (d *DHT) sendError(addr *net.UDPAddr, tid string, code int, message string) {
+	_ = d.send(addr, map[string]any{"t": tid, "y": "e", "e": []any{code, message}})
+}
+
+func (d *DHT) compactNodes(target string) string {
+	buf := new(bytes.Buffer)
+	for _, n := range d.table.closest(target, bucketSize) {
+		ip := n.addr.IP.To4()
+		if ip == nil {
+			continue
+		}
+		buf.WriteString(n.id)
+		buf.Write(ip)
+		binary.Write(buf, binary.BigEndian, uint16(n.addr.Port))
+	}
+	return buf.String()
+}
+
+func parseCompactNodes(s string) []remoteNode {
+	var nodes []remoteNode
+	for i := 0; i+26 <= len(s); i += 26 {
+		nodes = append(nodes, remoteNode{
+			id: s[i : i+20],
+			addr: &net.UDPAddr{
+				IP:   net.IP([]byte(s[i+20 : i+24])),
+				Port: int(binary.BigEndian.Uint16([]byte(s[i+24 : i+26]))),
+			},
+		})
+	}
+	return nodes
+}
+
+// rotateSecret must be called with d.mu held or before the node is shared.
+func (d *DHT) rotateSecret() {
+	secret := make([]byte, 20)
+	_, _ = rand.Read(secret)
+	d.secrets[1] = d.secrets[0]
+	d.secrets[0] = secret
+	d.rotated = time.Now()
+}
+
+// token returns the announce token for ip derived from the current (0) or
+// previous (1) secret.
+func (d *DHT) token(ip net.IP, generation int) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if time.Since(d.rotated) > tokenRotation {
+		d.rotateSecret()
+	}
+	hash := sha1.New()
+	hash.Write(d.secrets[generation])
+	hash.Write(ip)
+	return string(hash.Sum(nil))
+}
+
+func (d *DHT) validToken(ip net.IP, token string) bool {
+	return token != "" && (token == d.token(ip, 0) || token == d.token(ip, 1))
+}
+
+func (d *DHT) storePeer(infoHash, addr string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.peers[infoHash] == nil {
+		d.peers[infoHash] = map[string]time.Time{}
+	}
+	d.peers[infoHash][addr] = time.Now()
+}
+
+func (d *DHT) storedPeers(infoHash string) []any {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var values []any
+	for addr, seen := range d.peers[infoHash] {
+		if time.Since(seen) > peerTTL {
+			delete(d.peers[infoHash], addr)
+			continue
+		}
+		if compact := compactPeer(addr); compact != "" {
+			values = append(values, compact)
+		}
+	}
+	return values
+}
+
+func compactPeer(addr string) string {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil || tcpAddr.IP.To4() == nil {
+		return ""
+	}
+	return string(binary.BigEndian.AppendUint16(tcpAddr.IP.To4(), uint16(tcpAddr.Port)))
+}
+
+func (d *DHT) Ping(ctx context.Context, addr string) error {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	_, err = d.query(ctx, udpAddr, "ping", map[string]any{})
+	return err
+}
+
+// Bootstrap populates the routing table by looking up our own id through the
+// given nodes.
+func (d *DHT) Bootstrap(ctx context.Context, addrs []string) error {
+	d.lookup(ctx, d.id, "find_node", addrs)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if d.table.size() == 0 {
+		return fmt.Errorf("no dht nodes responded")
+	}
+	return nil
+}
+
+type lookupCandidate struct {
+	id      string
+	addr    *net.UDPAddr
+	queried bool
+	token   string
+}
+
+// lookup runs an iterative find_node or get_peers search for target. It
+// returns the peers found and the closest responding nodes along with the
+// tokens they handed out. seeds are queried first when the routing table
+// can't provide enough nodes. Cancelling ctx ends the search with what was
+// found so far.
+func (d *DHT) lookup(ctx context.Context, target, method string, seeds []string) ([]string, []lookupCandidate) {
+	arg := "target"
+	if method == "get_peers" {
+		arg = "info_hash"
+	}
+
+	var mu sync.Mutex
+	candidates := map[string]*lookupCandidate{}
+	var responded []*lookupCandidate
+	seenPeers := map[string]bool{}
+	var peers []string
+
+	handle := func(c *lookupCandidate, r map[string]any) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if id, ok := r["id"].(string); ok && len(id) == 20 && c.id == "" {
+			c.id = id
+		}
+		c.token, _ = r["token"].(string)
+		if c.id != "" {
+			responded = append(responded, c)
+		}
+
+		nodes, _ := r["nodes"].(string)
+		for _, n := range parseCompactNodes(nodes) {
+			if n.id == d.id {
+				continue
+			}
+			if _, ok := candidates[n.id]; !ok {
+				candidates[n.id] = &lookupCandidate{id: n.id, addr: n.addr}
+			}
+		}
+
+		values, _ := r["values"].([]any)
+		for _, v := range values {
+			s, _ := v.(string)
+			if len(s) != 6 {
+				continue
+			}
+			peer := fmt.Sprintf("%s:%d", net.IP([]byte(s[:4])), binary.BigEndian.Uint16([]byte(s[4:])))
+			if !seenPeers[peer] {
+				seenPeers[peer] = true
+				peers = append(peers, peer)
+			}
+		}
+	}
+
+	queryAll := func(batch []*lookupCandidate) {
+		var wg sync.WaitGroup
+		for _, c := range batch {
+			wg.Add(1)
+			go func(c *lookupCandidate) {
+				defer wg.Done()
+				r, err := d.query(ctx, c.addr, method, map[string]any{arg: target})
+				if err != nil {
+					// a node that wasn't given the time to answer isn't dead
+					if c.id != "" && ctx.Err() == nil {
+						d.table.remove(c.id)
+					}
+					return
+				}
+				handle(c, r)
+			}(c)
+		}
+		wg.Wait()
+	}
+
+	for _, n := range d.table.closest(target, bucketSize) {
+		candidates[n.id] = &lookupCandidate{id: n.id, addr: n.addr}
+	}
+	if len(candidates) < bucketSize {
+		var batch []*lookupCandidate
+		for _, seed := range seeds {
+			addr, err := net.ResolveUDPAddr("udp", seed)
+			if err != nil {
+				continue
+			}
+			batch = append(batch, &lookupCandidate{addr: addr, queried: true})
+		}
+		queryAll(batch)
+	}
+
+	for ctx.Err() == nil {
+		mu.Lock()
+		sorted := make([]*lookupCandidate, 0, len(candidates))
+		for _, c := range candidates {
+			sorted = append(sorted, c)
+		}
+		sort.Slice(sorted, func(i, j int) bool {
+			return distanceLess(target, sorted[i].id, sorted[j].id)
+		})
+		if len(sorted) > bucketSize {
+			sorted = sorted[:bucketSize]
+		}
+		var batch []*lookupCandidate
+		for _, c := range sorted {
+			if !c.queried && len(batch) < alpha {
+				c.queried = true
+				batch = append(batch, c)
+			}
+		}
+		mu.Unlock()
+
+		if len(batch) == 0 {
+			break
+		}
+		queryAll(batch)
+	}
+
+	sort.Slice(responded, func(i, j int) bool {
+		return distanceLess(target, responded[i].id, responded[j].id)
+	})
+	if len(responded) > bucketSize {
+		responded = responded[:bucketSize]
+	}
+	closest := make([]lookupCandidate, len(responded))
+	for i, c := range responded {
+		closest[i] = *c
+	}
+	return peers, closest
+}
+
+// GetPeers looks up peers for infoHash. When port is non-zero we also
+// announce ourselves to the closest nodes as a peer listening on port.
+func (d *DHT) GetPeers(ctx context.Context, infoHash []byte, port int) ([]string, error) {
+	if len(infoHash) != 20 {
+		return nil, fmt.Errorf("invalid info hash")
+	}
+
+	peers, closest := d.lookup(ctx, string(infoHash), "get_peers", BootstrapNodes)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(closest) == 0 {
+		return nil, fmt.Errorf("no dht nodes responded")
+	}
+
+	if port > 0 {
+		for _, c := range closest {
+			if c.token == "" {
+				continue
+			}
+			_, _ = d.query(ctx, c.addr, "announce_peer", map[string]any{
+				"info_hash": string(infoHash),
+				"port":      port,
+				"token":     c.token,
+			})
+		}
+	}
+
+	return peers, nil
+}
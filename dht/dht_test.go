@@ -0,0 +1,126 @@
+package dht
+
+import (
+	"context"
+	"crypto/sha1"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startNodes starts n nodes on loopback that bootstrap from the first one,
+// which also becomes the only bootstrap node for the test.
+func startNodes(t *testing.T, n int) []*DHT {
+	timeout, bootstrapNodes := queryTimeout, BootstrapNodes
+	queryTimeout = 300 * time.Millisecond
+	t.Cleanup(func() { queryTimeout, BootstrapNodes = timeout, bootstrapNodes })
+
+	var nodes []*DHT
+	for i := 0; i < n; i++ {
+		d, err := New("127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { d.Close() })
+		nodes = append(nodes, d)
+	}
+	BootstrapNodes = []string{nodes[0].Addr().String()}
+
+	// a second round lets the early nodes learn about the later ones
+	for round := 0; round < 2; round++ {
+		for _, d := range nodes[1:] {
+			if err := d.Bootstrap(context.Background(), BootstrapNodes); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return nodes
+}
+
+func TestBootstrap(t *testing.T) {
+	nodes := startNodes(t, 10)
+
+	for i, d := range nodes {
+		if size := d.table.size(); size < 2 {
+			t.Errorf("node %d knows %d nodes", i, size)
+		}
+	}
+	if err := nodes[3].Ping(context.Background(), nodes[7].Addr().String()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBootstrapNoNodes(t *testing.T) {
+	timeout := queryTimeout
+	queryTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { queryTimeout = timeout })
+
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	d, err := New("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	if err := d.Bootstrap(context.Background(), []string{silent.LocalAddr().String()}); err == nil {
+		t.Fatal("bootstrap succeeded without any node answering")
+	}
+}
+
+func TestGetPeersAnnounce(t *testing.T) {
+	nodes := startNodes(t, 10)
+	infoHash := sha1.Sum([]byte("torrent"))
+
+	peers, err := nodes[4].GetPeers(context.Background(), infoHash[:], 7777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(peers) != 0 {
+		t.Fatalf("got peers %v before anyone announced", peers)
+	}
+
+	// lookups without a port find the peer announced above and add none
+	for _, d := range []*DHT{nodes[8], nodes[2]} {
+		peers, err = d.GetPeers(context.Background(), infoHash[:], 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(peers, ","); got != "127.0.0.1:7777" {
+			t.Fatalf("got peers %s, want 127.0.0.1:7777", got)
+		}
+	}
+}
+
+func TestAnnounceInvalidToken(t *testing.T) {
+	nodes := startNodes(t, 2)
+	infoHash := sha1.Sum([]byte("torrent"))
+	addr := nodes[0].Addr().(*net.UDPAddr)
+
+	_, err := nodes[1].query(context.Background(), addr, "announce_peer", map[string]any{
+		"info_hash": string(infoHash[:]),
+		"port":      7777,
+		"token":     "forged",
+	})
+	if err == nil {
+		t.Fatal("announce with a forged token was accepted")
+	}
+	if values := nodes[0].storedPeers(string(infoHash[:])); len(values) != 0 {
+		t.Fatalf("stored peers %v", values)
+	}
+}
+
+func TestGetPeersCancel(t *testing.T) {
+	nodes := startNodes(t, 3)
+	infoHash := sha1.Sum([]byte("torrent"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := nodes[1].GetPeers(ctx, infoHash[:], 0); err != context.Canceled {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,112 @@
+package metainfo
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ContentPath is where entry of torrentInfo lives below target. A
+// single-file torrent is stored at target itself, a multi-file torrent in
+// target/name/path....
+func ContentPath(target string, torrentInfo *TorrentInfo, entry FileEntry) (string, error) {
+	if !torrentInfo.IsMultiFile() {
+		return target, nil
+	}
+
+	if !filepath.IsLocal(entry.Path) {
+		return "", fmt.Errorf("invalid file path %q", entry.Path)
+	}
+	return filepath.Join(target, entry.Path), nil
+}
+
+// ForEachFile splits the part of the content that p covers at off across the
+// file entries holding it and calls fn with each entry's index, its part of p
+// and the offset within the file. It returns io.EOF when p extends past the
+// end of the content.
+func ForEachFile(entries []FileEntry, p []byte, off int64, fn func(i int, chunk []byte, fileOff int64) (int, error)) (int, error) {
+	n := 0
+	for i, entry := range entries {
+		if len(p) == 0 {
+			break
+		}
+		fileOff := off + int64(n) - int64(entry.Offset)
+		if fileOff < 0 || fileOff >= int64(entry.Length) {
+			continue
+		}
+
+		chunk := p
+		if remaining := int64(entry.Length) - fileOff; int64(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+		}
+		done, err := fn(i, chunk, fileOff)
+		n += done
+		if err != nil {
+			return n, err
+		}
+		p = p[done:]
+	}
+
+	if len(p) > 0 {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// ContentReader reads the concatenated torrent content at arbitrary offsets
+// from the files laid out under a download root. Padding reads as zeros.
+type ContentReader struct {
+	mu      sync.Mutex
+	entries []FileEntry
+	paths   []string
+	files   []*os.File
+}
+
+func NewContentReader(target string, torrentInfo *TorrentInfo) (*ContentReader, error) {
+	r := &ContentReader{entries: torrentInfo.FileEntries()}
+	for _, entry := range r.entries {
+		path, err := ContentPath(target, torrentInfo, entry)
+		if err != nil {
+			return nil, err
+		}
+		r.paths = append(r.paths, path)
+	}
+	r.files = make([]*os.File, len(r.paths))
+	return r, nil
+}
+
+func (r *ContentReader) ReadAt(p []byte, off int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return ForEachFile(r.entries, p, off, func(i int, chunk []byte, fileOff int64) (int, error) {
+		if r.entries[i].Padding {
+			clear(chunk)
+			return len(chunk), nil
+		}
+
+		if r.files[i] == nil {
+			file, err := os.Open(r.paths[i])
+			if err != nil {
+				return 0, err
+			}
+			r.files[i] = file
+		}
+		return r.files[i].ReadAt(chunk, fileOff)
+	})
+}
+
+func (r *ContentReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, file := range r.files {
+		if file != nil {
+			file.Close()
+			r.files[i] = nil
+		}
+	}
+	return nil
+}
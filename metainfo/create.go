@@ -0,0 +1,161 @@
+package metainfo
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Bounds for the piece length of torrents we create.
+const (
+	minPieceLength = 16 * 1024        // 16KB
+	maxPieceLength = 16 * 1024 * 1024 // 16MB
+)
+
+// targetPieceCount is roughly how many pieces an automatically chosen piece
+// length aims for.
+const targetPieceCount = 1500
+
+// NewTorrentInfoFromPath builds the info dictionary for the file or
+// directory at path and hashes its pieces. A pieceLength of 0 picks one from
+// the content size.
+func NewTorrentInfoFromPath(path string, pieceLength int) (*TorrentInfo, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	torrentInfo := &TorrentInfo{Name: filepath.Base(path)}
+	target := path
+	if stat.IsDir() {
+		if torrentInfo.Files, err = walkFiles(path); err != nil {
+			return nil, err
+		}
+		if len(torrentInfo.Files) == 0 {
+			return nil, fmt.Errorf("no files in %s", path)
+		}
+		// multi-file content lives under target/name
+		target = filepath.Dir(path)
+	} else {
+		torrentInfo.Length = int(stat.Size())
+	}
+
+	if pieceLength == 0 {
+		pieceLength = choosePieceLength(torrentInfo.TotalLength())
+	}
+	if pieceLength < minPieceLength || pieceLength > maxPieceLength || pieceLength&(pieceLength-1) != 0 {
+		return nil, fmt.Errorf("piece length must be a power of two between %d and %d", minPieceLength, maxPieceLength)
+	}
+	torrentInfo.PieceLength = pieceLength
+
+	if torrentInfo.Pieces, err = hashPieces(target, torrentInfo); err != nil {
+		return nil, err
+	}
+	return torrentInfo, nil
+}
+
+// walkFiles lists the regular files below root in lexical order.
+func walkFiles(root string) ([]TorrentFile, error) {
+	var files []TorrentFile
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, TorrentFile{
+			Length: int(info.Size()),
+			Path:   strings.Split(filepath.ToSlash(rel), "/"),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walk %s: %w", root, err)
+	}
+	return files, nil
+}
+
+// choosePieceLength returns the smallest power of two piece length that
+// keeps the piece count near targetPieceCount.
+func choosePieceLength(totalLength int) int {
+	pieceLength := minPieceLength
+	for pieceLength < maxPieceLength && totalLength/pieceLength > targetPieceCount {
+		pieceLength *= 2
+	}
+	return pieceLength
+}
+
+// hashPieces returns the concatenated SHA-1 piece hashes of the content of
+// torrentInfo stored at target. Pieces are hashed on all cores.
+func hashPieces(target string, torrentInfo *TorrentInfo) (string, error) {
+	pieceCount := (torrentInfo.TotalLength() + torrentInfo.PieceLength - 1) / torrentInfo.PieceLength
+	pieces := make([]byte, pieceCount*sha1.Size)
+
+	indexes := make(chan int)
+	errs := make(chan error, runtime.NumCPU())
+	workers := sync.WaitGroup{}
+	for i := 0; i < runtime.NumCPU(); i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+
+			// a reader per worker, as ContentReader keeps its files open
+			content, err := NewContentReader(target, torrentInfo)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer content.Close()
+
+			buf := make([]byte, torrentInfo.PieceLength)
+			for index := range indexes {
+				piece := buf[:torrentInfo.PieceSize(index)]
+				if _, err := content.ReadAt(piece, int64(index)*int64(torrentInfo.PieceLength)); err != nil {
+					errs <- fmt.Errorf("read piece %d: %w", index, err)
+					return
+				}
+				hash := sha1.Sum(piece)
+				copy(pieces[index*sha1.Size:], hash[:])
+			}
+		}()
+	}
+
+	go func() {
+		defer close(indexes)
+		for index := 0; index < pieceCount; index++ {
+			select {
+			case indexes <- index:
+			case err := <-errs:
+				errs <- err
+				return
+			}
+		}
+	}()
+	workers.Wait()
+
+	select {
+	case err := <-errs:
+		return "", err
+	default:
+	}
+	return string(pieces), nil
+}
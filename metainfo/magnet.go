@@ -0,0 +1,102 @@
+package metainfo
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+type Magnet struct {
+	TrackerURL  string
+	TrackerURLs []string
+
+	// InfoHash is the 20-byte hash used on the wire: the v1 hash, or the
+	// truncated v2 hash for v2-only magnets.
+	InfoHash   []byte
+	InfoHashV2 []byte
+
+	// WebSeeds are the ws parameters.
+	// https://www.bittorrent.org/beps/bep_0019.html
+	WebSeeds []string
+}
+
+func NewMagnet(magnet string) (*Magnet, error) {
+	u, err := url.Parse(magnet)
+	if err != nil {
+		return nil, err
+	}
+
+	q := u.Query()
+
+	m := &Magnet{
+		TrackerURL:  q.Get("tr"),
+		TrackerURLs: q["tr"],
+		WebSeeds:    q["ws"],
+	}
+
+	// hybrid magnets carry both exact topics
+	for _, xt := range q["xt"] {
+		switch {
+		case strings.HasPrefix(xt, "urn:btih:"):
+			if m.InfoHash, err = hex.DecodeString(strings.TrimPrefix(xt, "urn:btih:")); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(xt, "urn:btmh:"):
+			if m.InfoHashV2, err = parseMultihash(strings.TrimPrefix(xt, "urn:btmh:")); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if m.InfoHash == nil && m.InfoHashV2 != nil {
+		m.InfoHash = m.InfoHashV2[:20]
+	}
+	if len(m.InfoHash) != 20 {
+		return nil, fmt.Errorf("magnet has no valid info hash")
+	}
+
+	return m, nil
+}
+
+// parseMultihash decodes the hex multihash of a v2 info hash, which must be
+// a SHA-256 hash.
+// https://www.bittorrent.org/beps/bep_0052.html
+func parseMultihash(s string) ([]byte, error) {
+	multihash, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	// 0x12 is sha2-256 and 0x20 its length
+	if len(multihash) != 34 || multihash[0] != 0x12 || multihash[1] != 0x20 {
+		return nil, fmt.Errorf("unsupported multihash %q", s)
+	}
+	return multihash[2:], nil
+}
+
+// Trackers returns the magnet's trackers, each in a tier of its own so that
+// peers from every responsive tracker are merged.
+func (m *Magnet) Trackers() [][]string {
+	var tiers [][]string
+	for _, trackerURL := range m.TrackerURLs {
+		tiers = append(tiers, []string{trackerURL})
+	}
+	return tiers
+}
+
+// Torrent builds a torrent from the magnet's trackers and the metadata
+// fetched from peers.
+func (m *Magnet) Torrent(torrentInfo *TorrentInfo) *Torrent {
+	torrent := &Torrent{
+		Announce: m.TrackerURL,
+		Info:     *torrentInfo,
+
+		// v2 files larger than a piece need their piece layers
+		PieceLayers: torrentInfo.PieceLayers(),
+
+		URLList: m.WebSeeds,
+	}
+	if len(m.TrackerURLs) > 1 {
+		torrent.AnnounceList = m.Trackers()
+	}
+	return torrent
+}
@@ -0,0 +1,332 @@
+// Package metainfo reads and writes .torrent files and magnet links: the
+// info dictionary with its v1 and v2 hashes, the layout of the content on
+// disk and the verification of pieces.
+package metainfo
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
+)
+
+type TorrentFile struct {
+	Length int      `bencode:"length"`
+	Path   []string `bencode:"path"`
+
+	// Attr holds the file attributes; "p" marks the padding files that
+	// align the files of hybrid torrents to piece boundaries.
+	// https://www.bittorrent.org/beps/bep_0047.html
+	Attr string `bencode:"attr,omitempty"`
+
+	// PiecesRoot is the merkle root of a v2 file, taken from the file tree.
+	PiecesRoot string `bencode:"-"`
+}
+
+type TorrentInfo struct {
+	Length      int           `bencode:"length,omitempty"`
+	Files       []TorrentFile `bencode:"files,omitempty"`
+	Name        string        `bencode:"name"`
+	PieceLength int           `bencode:"piece length"`
+	Pieces      string        `bencode:"pieces,omitempty"`
+	Private     int           `bencode:"private,omitempty"`
+	MetaVersion int           `bencode:"meta version,omitempty"`
+
+	// raw holds the info dictionary exactly as it was encoded, so keys the
+	// struct doesn't model still contribute to the info hash.
+	raw []byte `bencode:"-"`
+
+	// fileTree lists the files of a v2 info dictionary in content order.
+	fileTree []TorrentFile `bencode:"-"`
+
+	// pieceLayers maps the pieces root of every v2 file larger than a piece
+	// to the concatenated SHA-256 hashes of its pieces.
+	pieceLayers map[string]string `bencode:"-"`
+}
+
+type Torrent struct {
+	Announce     string      `bencode:"announce"`
+	AnnounceList [][]string  `bencode:"announce-list,omitempty"`
+	Comment      string      `bencode:"comment,omitempty"`
+	CreatedBy    string      `bencode:"created by,omitempty"`
+	CreationDate int         `bencode:"creation date,omitempty"`
+	Info         TorrentInfo `bencode:"info"`
+
+	// PieceLayers holds the piece hashes of v2 files, keyed by pieces root.
+	PieceLayers map[string]string `bencode:"piece layers,omitempty"`
+
+	// URLList holds the web seeds. It may be encoded as a list or, with a
+	// single seed, as a plain string.
+	// https://www.bittorrent.org/beps/bep_0019.html
+	URLList []string `bencode:"url-list,omitempty"`
+}
+
+func NewTorrent(path string) (*Torrent, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var torrent Torrent
+	err = bencode.Unmarshal(bytes.NewReader(data), &torrent)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := bencode.RawDictValue(data, "info")
+	if err != nil {
+		return nil, fmt.Errorf("read info: %w", err)
+	}
+	if err := torrent.Info.setRaw(raw); err != nil {
+		return nil, err
+	}
+	if err := torrent.Info.SetPieceLayers(torrent.PieceLayers); err != nil {
+		return nil, err
+	}
+
+	if len(torrent.URLList) == 0 {
+		if value, err := bencode.RawDictValue(data, "url-list"); err == nil {
+			if seed, err := bencode.Decode(bytes.NewReader(value)); err == nil {
+				if seed, ok := seed.(string); ok && seed != "" {
+					torrent.URLList = []string{seed}
+				}
+			}
+		}
+	}
+
+	return &torrent, nil
+}
+
+// NewTorrentInfo decodes a bencoded info dictionary, such as the metadata
+// received from a peer, keeping its original bytes for hashing.
+func NewTorrentInfo(raw []byte) (*TorrentInfo, error) {
+	var torrentInfo TorrentInfo
+	if err := bencode.Unmarshal(bytes.NewReader(raw), &torrentInfo); err != nil {
+		return nil, err
+	}
+	if err := torrentInfo.setRaw(raw); err != nil {
+		return nil, err
+	}
+	return &torrentInfo, nil
+}
+
+// validate checks that the v1 piece hashes cover the content exactly, so
+// piece sizes and offsets derived from the info dictionary are in range.
+func (t *TorrentInfo) validate() error {
+	if t.PieceLength <= 0 {
+		return fmt.Errorf("invalid piece length %d", t.PieceLength)
+	}
+	if !t.IsV1() {
+		return nil
+	}
+	for _, entry := range t.FileEntries() {
+		if entry.Length < 0 {
+			return fmt.Errorf("invalid length %d of %s", entry.Length, entry.Path)
+		}
+	}
+	if len(t.Pieces)%20 != 0 {
+		return fmt.Errorf("pieces length %d is not a multiple of 20", len(t.Pieces))
+	}
+	want := (t.TotalLength() + t.PieceLength - 1) / t.PieceLength
+	if count := t.PieceCount(); count != want {
+		return fmt.Errorf("%d piece hashes for %d bytes, want %d", count, t.TotalLength(), want)
+	}
+	return nil
+}
+
+// Bytes returns the bencoded info dictionary. The original encoding is used
+// when available; otherwise the struct is marshaled.
+func (t *TorrentInfo) Bytes() []byte {
+	if t.raw != nil {
+		return t.raw
+	}
+
+	buf := new(bytes.Buffer)
+	_ = bencode.Marshal(buf, *t)
+	return buf.Bytes()
+}
+
+// MarshalBinary encodes the torrent as a .torrent file. The info dictionary
+// is written with Bytes so the info hash is preserved.
+func (t *Torrent) MarshalBinary() ([]byte, error) {
+	// keys in sorted order, leaving out empty optional ones
+	fields := []struct {
+		key   string
+		value any
+		empty bool
+	}{
+		{"announce", t.Announce, t.Announce == ""},
+		{"announce-list", t.AnnounceList, len(t.AnnounceList) == 0},
+		{"comment", t.Comment, t.Comment == ""},
+		{"created by", t.CreatedBy, t.CreatedBy == ""},
+		{"creation date", t.CreationDate, t.CreationDate == 0},
+		{"info", nil, false},
+		{"piece layers", t.PieceLayers, len(t.PieceLayers) == 0},
+		{"url-list", t.URLList, len(t.URLList) == 0},
+	}
+
+	buf := new(bytes.Buffer)
+	buf.WriteString("d")
+	for _, field := range fields {
+		if field.empty {
+			continue
+		}
+		if err := bencode.Marshal(buf, field.key); err != nil {
+			return nil, fmt.Errorf("marshal %s: %w", field.key, err)
+		}
+		if field.key == "info" {
+			buf.Write(t.Info.Bytes())
+			continue
+		}
+		if err := bencode.Marshal(buf, field.value); err != nil {
+			return nil, fmt.Errorf("marshal %s: %w", field.key, err)
+		}
+	}
+	buf.WriteString("e")
+	return buf.Bytes(), nil
+}
+
+// Hash returns the 20-byte info hash used in handshakes and announces: the
+// SHA-1 hash for v1 and hybrid torrents and the truncated SHA-256 hash for
+// v2-only torrents.
+func (t *TorrentInfo) Hash() []byte {
+	if !t.IsV1() {
+		return t.HashV2()[:20]
+	}
+	hash := sha1.Sum(t.Bytes())
+	return hash[:]
+}
+
+// HashV2 returns the SHA-256 info hash of a v2 or hybrid torrent.
+func (t *TorrentInfo) HashV2() []byte {
+	hash := sha256.Sum256(t.Bytes())
+	return hash[:]
+}
+
+// IsV1 reports whether the torrent uses v1 SHA-1 piece hashes, which is
+// every torrent but v2-only ones.
+func (t *TorrentInfo) IsV1() bool {
+	return !t.IsV2() || t.Pieces != ""
+}
+
+// IsV2 reports whether the torrent carries a v2 file tree. Hybrid torrents
+// are both v1 and v2.
+// https://www.bittorrent.org/beps/bep_0052.html
+func (t *TorrentInfo) IsV2() bool {
+	return t.MetaVersion == 2
+}
+
+func (t *TorrentInfo) PieceHashes() [][]byte {
+	var hashes [][]byte
+	for i := 0; i < len(t.Pieces); i += 20 {
+		hashes = append(hashes, []byte(t.Pieces[i:i+20]))
+	}
+	return hashes
+}
+
+// IsMultiFile reports whether the info dictionary uses the multi-file
+// "files" layout instead of a single "length".
+func (t *TorrentInfo) IsMultiFile() bool {
+	if !t.IsV1() {
+		return len(t.fileTree) != 1 || len(t.fileTree[0].Path) != 1 || t.fileTree[0].Path[0] != t.Name
+	}
+	return len(t.Files) > 0
+}
+
+// TotalLength returns the size of the content described by the torrent,
+// summed across all files for multi-file torrents.
+func (t *TorrentInfo) TotalLength() int {
+	if !t.IsV1() {
+		entries := t.FileEntries()
+		if len(entries) == 0 {
+			return 0
+		}
+		last := entries[len(entries)-1]
+		return last.Offset + last.Length
+	}
+	if !t.IsMultiFile() {
+		return t.Length
+	}
+
+	total := 0
+	for _, file := range t.Files {
+		total += file.Length
+	}
+	return total
+}
+
+func (t *TorrentInfo) PieceCount() int {
+	if !t.IsV1() {
+		return (t.TotalLength() + t.PieceLength - 1) / t.PieceLength
+	}
+	return len(t.Pieces) / 20
+}
+
+// PieceSize returns the length of the piece at index, which is shorter than
+// PieceLength only for the last piece. In v2-only torrents the last piece of
+// every file is short, as the padding up to the next file is not transferred.
+func (t *TorrentInfo) PieceSize(index int) int {
+	begin := index * t.PieceLength
+	end := begin + t.PieceLength
+	if !t.IsV1() {
+		if file, offset, ok := t.pieceFile(index); ok {
+			return min(end, offset+file.Length) - begin
+		}
+		return 0
+	}
+	if total := t.TotalLength(); end > total {
+		end = total
+	}
+	return end - begin
+}
+
+// FileEntry is a file of the torrent content resolved to a relative path
+// and its byte offset within the concatenated content.
+type FileEntry struct {
+	Path   string
+	Offset int
+	Length int
+
+	// Padding entries only align the next file to a piece boundary. They
+	// are all zeros and never stored on disk.
+	Padding bool
+}
+
+// FileEntries lays out the files of the torrent in content order. Paths are
+// relative to the download root: the file name for single-file torrents and
+// name/path... for multi-file torrents.
+func (t *TorrentInfo) FileEntries() []FileEntry {
+	if !t.IsV1() {
+		return t.fileTreeEntries()
+	}
+	if !t.IsMultiFile() {
+		return []FileEntry{{Path: t.Name, Length: t.Length}}
+	}
+
+	entries := make([]FileEntry, 0, len(t.Files))
+	offset := 0
+	for _, file := range t.Files {
+		entries = append(entries, FileEntry{
+			Path:    filepath.Join(append([]string{t.Name}, file.Path...)...),
+			Offset:  offset,
+			Length:  file.Length,
+			Padding: strings.Contains(file.Attr, "p"),
+		})
+		offset += file.Length
+	}
+	return entries
+}
+
+// Trackers returns the tracker tiers of the torrent. The announce-list takes
+// precedence over announce when present, as required by BEP 12.
+func (t *Torrent) Trackers() [][]string {
+	if len(t.AnnounceList) > 0 {
+		return t.AnnounceList
+	}
+	return [][]string{{t.Announce}}
+}
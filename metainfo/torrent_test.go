@@ -0,0 +1,42 @@
+package metainfo
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// infoDict bencodes a single-file info dictionary with count piece hashes.
+func infoDict(length, pieceLength, count int) []byte {
+	pieces := strings.Repeat("h", 20*count)
+	return []byte(fmt.Sprintf("d6:lengthi%de4:name5:a.bin12:piece lengthi%de6:pieces%d:%se",
+		length, pieceLength, len(pieces), pieces))
+}
+
+func TestNewTorrentInfo(t *testing.T) {
+	torrentInfo, err := NewTorrentInfo(infoDict(40000, 16384, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := torrentInfo.PieceSize(2); size != 40000-2*16384 {
+		t.Errorf("last piece size %d", size)
+	}
+}
+
+func TestNewTorrentInfoInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  []byte
+	}{
+		{"too many pieces", infoDict(10, 16384, 2)},
+		{"too few pieces", infoDict(40000, 16384, 2)},
+		{"zero piece length", infoDict(10, 0, 1)},
+		{"negative piece length", infoDict(10, -16384, 1)},
+		{"truncated hash", []byte("d6:lengthi10e4:name5:a.bin12:piece lengthi16384e6:pieces19:hhhhhhhhhhhhhhhhhhhe")},
+	}
+	for _, test := range tests {
+		if _, err := NewTorrentInfo(test.raw); err == nil {
+			t.Errorf("%s: no error", test.name)
+		}
+	}
+}
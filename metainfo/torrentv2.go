@@ -0,0 +1,320 @@
+package metainfo
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
+)
+
+// https://www.bittorrent.org/beps/bep_0052.html
+
+// MerkleBlockSize is the size of the leaves of the v2 merkle trees.
+const MerkleBlockSize = 16 * 1024 // 16KB
+
+// setRaw stores the original encoding of the info dictionary and, for v2
+// torrents, decodes the file tree, which the struct can't model.
+func (t *TorrentInfo) setRaw(raw []byte) error {
+	t.raw = raw
+	if err := t.validate(); err != nil {
+		return err
+	}
+	if !t.IsV2() {
+		return nil
+	}
+
+	if t.PieceLength < MerkleBlockSize || t.PieceLength&(t.PieceLength-1) != 0 {
+		return fmt.Errorf("invalid piece length %d", t.PieceLength)
+	}
+
+	value, err := bencode.RawDictValue(raw, "file tree")
+	if err != nil {
+		return fmt.Errorf("read file tree: %w", err)
+	}
+	tree, err := bencode.Decode(bytes.NewReader(value))
+	if err != nil {
+		return fmt.Errorf("decode file tree: %w", err)
+	}
+
+	t.fileTree = nil
+	if err := t.walkFileTree(tree, nil); err != nil {
+		return fmt.Errorf("file tree: %w", err)
+	}
+	if len(t.fileTree) == 0 {
+		return fmt.Errorf("file tree: no files")
+	}
+	return nil
+}
+
+// walkFileTree appends the files below node to t.fileTree in key order,
+// which is the content order of v2 torrents.
+func (t *TorrentInfo) walkFileTree(node any, path []string) error {
+	dir, ok := node.(map[string]any)
+	if !ok {
+		return fmt.Errorf("%q is not a dictionary", filepath.Join(path...))
+	}
+
+	if properties, ok := dir[""]; ok {
+		if len(path) == 0 || len(dir) != 1 {
+			return fmt.Errorf("invalid file entry %q", filepath.Join(path...))
+		}
+		file, ok := properties.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%q is not a dictionary", filepath.Join(path...))
+		}
+
+		length, ok := file["length"].(int64)
+		if !ok || length < 0 {
+			return fmt.Errorf("invalid length for %q", filepath.Join(path...))
+		}
+		root, _ := file["pieces root"].(string)
+		if length > 0 && len(root) != sha256.Size {
+			return fmt.Errorf("invalid pieces root for %q", filepath.Join(path...))
+		}
+
+		t.fileTree = append(t.fileTree, TorrentFile{
+			Length:     int(length),
+			Path:       slices.Clone(path),
+			PiecesRoot: root,
+		})
+		return nil
+	}
+
+	names := make([]string, 0, len(dir))
+	for name := range dir {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		if name == "." || name == ".." || !filepath.IsLocal(name) || filepath.Base(name) != name {
+			return fmt.Errorf("invalid file name %q", name)
+		}
+		if err := t.walkFileTree(dir[name], append(path, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileTreeEntries lays out the files of a v2 torrent. Every file starts on
+// a piece boundary, so padding entries fill the gaps in between.
+func (t *TorrentInfo) fileTreeEntries() []FileEntry {
+	multiFile := t.IsMultiFile()
+
+	var entries []FileEntry
+	offset := 0
+	for i, file := range t.fileTree {
+		path := t.Name
+		if multiFile {
+			path = filepath.Join(append([]string{t.Name}, file.Path...)...)
+		}
+		entries = append(entries, FileEntry{Path: path, Offset: offset, Length: file.Length})
+		offset += file.Length
+
+		if pad := (t.PieceLength - offset%t.PieceLength) % t.PieceLength; pad > 0 && i < len(t.fileTree)-1 {
+			entries = append(entries, FileEntry{
+				Path:    filepath.Join(t.Name, ".pad", strconv.Itoa(pad)),
+				Offset:  offset,
+				Length:  pad,
+				Padding: true,
+			})
+			offset += pad
+		}
+	}
+	return entries
+}
+
+// pieceFile returns the v2 file holding piece index and the offset of that
+// file within the content.
+func (t *TorrentInfo) pieceFile(index int) (TorrentFile, int, bool) {
+	begin := index * t.PieceLength
+	offset := 0
+	for _, file := range t.fileTree {
+		if begin >= offset && begin < offset+file.Length {
+			return file, offset, true
+		}
+		offset += (file.Length + t.PieceLength - 1) / t.PieceLength * t.PieceLength
+	}
+	return TorrentFile{}, 0, false
+}
+
+// MissingPieceLayers returns the v2 files larger than a piece whose piece
+// layer is not known yet. Magnet downloads request them from peers.
+func (t *TorrentInfo) MissingPieceLayers() []TorrentFile {
+	if t.IsV1() {
+		return nil
+	}
+
+	var files []TorrentFile
+	for _, file := range t.fileTree {
+		if _, ok := t.pieceLayers[file.PiecesRoot]; file.Length > t.PieceLength && !ok {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// SetPieceLayers adds the piece layers of a .torrent file.
+func (t *TorrentInfo) SetPieceLayers(layers map[string]string) error {
+	for root, layer := range layers {
+		if err := t.AddPieceLayer(root, []byte(layer)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddPieceLayer adds the piece hashes of the file with the given pieces
+// root after checking that they hash up to it.
+func (t *TorrentInfo) AddPieceLayer(root string, layer []byte) error {
+	i := slices.IndexFunc(t.fileTree, func(file TorrentFile) bool { return file.PiecesRoot == root })
+	if i < 0 {
+		return fmt.Errorf("piece layer for unknown pieces root %x", root)
+	}
+
+	count := (t.fileTree[i].Length + t.PieceLength - 1) / t.PieceLength
+	if len(layer) != count*sha256.Size {
+		return fmt.Errorf("piece layer for %x has %d bytes", root, len(layer))
+	}
+	hashes := splitHashes(layer)
+	if !bytes.Equal(merkleRoot(hashes, nextPowerOfTwo(count), t.padHash()), []byte(root)) {
+		return fmt.Errorf("piece layer for %x does not match its root", root)
+	}
+
+	if t.pieceLayers == nil {
+		t.pieceLayers = map[string]string{}
+	}
+	t.pieceLayers[root] = string(layer)
+	return nil
+}
+
+// VerifyHashes checks hashes a peer sent for the piece layer of file:
+// length hashes of the layer starting at index, length being a power of two,
+// followed by the uncle hashes leading from their subtree up to the pieces
+// root. It returns the piece hashes without the padding past the end of the
+// layer.
+func (t *TorrentInfo) VerifyHashes(file TorrentFile, index, length int, hashes []byte) ([]byte, error) {
+	if len(hashes) < length*sha256.Size {
+		return nil, fmt.Errorf("hashes for %x: got %d bytes", file.PiecesRoot, len(hashes))
+	}
+
+	// walk up from the root of the chunk with the uncle hashes
+	chunk := splitHashes(hashes[:length*sha256.Size])
+	root := merkleRoot(chunk, length, t.padHash())
+	position := index / length
+	for _, uncle := range splitHashes(hashes[length*sha256.Size:]) {
+		if position%2 == 0 {
+			root = hashPair(root, uncle)
+		} else {
+			root = hashPair(uncle, root)
+		}
+		position /= 2
+	}
+	if !bytes.Equal(root, []byte(file.PiecesRoot)) {
+		return nil, fmt.Errorf("hashes for %x do not match the pieces root", file.PiecesRoot)
+	}
+
+	count := (file.Length + t.PieceLength - 1) / t.PieceLength
+	return hashes[:min(length, count-index)*sha256.Size], nil
+}
+
+// PieceLayers returns the verified piece layers, keyed by pieces root.
+func (t *TorrentInfo) PieceLayers() map[string]string {
+	return t.pieceLayers
+}
+
+// VerifyPiece reports whether data is piece index: its SHA-1 hash for v1
+// and hybrid torrents, the merkle root of its blocks for v2 torrents.
+func (t *TorrentInfo) VerifyPiece(index int, data []byte) bool {
+	if t.IsV1() {
+		hashes := t.PieceHashes()
+		if index < 0 || index >= len(hashes) {
+			return false
+		}
+		hash := sha1.Sum(data)
+		return bytes.Equal(hash[:], hashes[index])
+	}
+
+	file, offset, ok := t.pieceFile(index)
+	if !ok || len(data) != t.PieceSize(index) {
+		return false
+	}
+
+	leaves := blockHashes(data)
+	if file.Length <= t.PieceLength {
+		// small files have no piece layer, the root covers the whole file
+		return bytes.Equal(merkleRoot(leaves, nextPowerOfTwo(len(leaves)), nil), []byte(file.PiecesRoot))
+	}
+
+	layer, ok := t.pieceLayers[file.PiecesRoot]
+	if !ok {
+		return false
+	}
+	i := (index*t.PieceLength - offset) / t.PieceLength
+	expected := layer[i*sha256.Size : (i+1)*sha256.Size]
+	return bytes.Equal(merkleRoot(leaves, t.PieceLength/MerkleBlockSize, nil), []byte(expected))
+}
+
+// padHash is the root of a piece made of zero leaves, which pads the piece
+// layer up to a power of two.
+func (t *TorrentInfo) padHash() []byte {
+	return merkleRoot(nil, t.PieceLength/MerkleBlockSize, nil)
+}
+
+// blockHashes returns the SHA-256 hashes of the blocks of data.
+func blockHashes(data []byte) [][]byte {
+	var hashes [][]byte
+	for begin := 0; begin < len(data); begin += MerkleBlockSize {
+		hash := sha256.Sum256(data[begin:min(begin+MerkleBlockSize, len(data))])
+		hashes = append(hashes, hash[:])
+	}
+	return hashes
+}
+
+func splitHashes(data []byte) [][]byte {
+	var hashes [][]byte
+	for i := 0; i+sha256.Size <= len(data); i += sha256.Size {
+		hashes = append(hashes, data[i:i+sha256.Size])
+	}
+	return hashes
+}
+
+// merkleRoot returns the root of the tree with width leaves, a power of two,
+// whose leading leaves are hashes and the rest pad. A nil pad stands for
+// the zero hash.
+func merkleRoot(hashes [][]byte, width int, pad []byte) []byte {
+	if pad == nil {
+		pad = make([]byte, sha256.Size)
+	}
+
+	layer := slices.Clone(hashes)
+	for len(layer) < width {
+		layer = append(layer, pad)
+	}
+	for len(layer) > 1 {
+		next := make([][]byte, 0, len(layer)/2)
+		for i := 0; i < len(layer); i += 2 {
+			next = append(next, hashPair(layer[i], layer[i+1]))
+		}
+		layer = next
+	}
+	return layer[0]
+}
+
+func hashPair(left, right []byte) []byte {
+	hash := sha256.Sum256(append(slices.Clone(left), right...))
+	return hash[:]
+}
+
+func nextPowerOfTwo(n int) int {
+	power := 1
+	for power < n {
+		power *= 2
+	}
+	return power
+}
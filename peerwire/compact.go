@@ -0,0 +1,39 @@
+package peerwire
+
+import (
+	"encoding/binary"
+	"net"
+	"strconv"
+)
+
+// https://www.bittorrent.org/beps/bep_0023.html
+
+// CompactPeers splits a compact peer list of addresses ipLen bytes long,
+// each followed by a two byte port.
+func CompactPeers(data string, ipLen int) []string {
+	var peers []string
+	for i := 0; i+ipLen+2 <= len(data); i += ipLen + 2 {
+		ip := net.IP(data[i : i+ipLen])
+		port := binary.BigEndian.Uint16([]byte(data[i+ipLen : i+ipLen+2]))
+		peers = append(peers, net.JoinHostPort(ip.String(), strconv.Itoa(int(port))))
+	}
+	return peers
+}
+
+// CompactAddr encodes a host:port address with a literal IP in the compact
+// peer format and reports whether it is an IPv6 address.
+func CompactAddr(addr string) (compact string, ipv6 bool, ok bool) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", false, false
+	}
+	ip := net.ParseIP(host)
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if ip == nil || err != nil {
+		return "", false, false
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return string(binary.BigEndian.AppendUint16(ip4, uint16(port))), false, true
+	}
+	return string(binary.BigEndian.AppendUint16(ip.To16(), uint16(port))), true, true
+}
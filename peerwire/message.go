@@ -0,0 +1,366 @@
+// Package peerwire encodes and decodes the messages of the BitTorrent peer
+// wire protocol and its extensions.
+// https://www.bittorrent.org/beps/bep_0003.html
+package peerwire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
+)
+
+// Protocol is the protocol string of the handshake.
+const Protocol = "BitTorrent protocol"
+
+type HandshakeMessage struct {
+	Protocol string
+	Reserved [8]byte
+	InfoHash []byte
+	PeerID   []byte
+}
+
+// https://www.bittorrent.org/beps/bep_0010.html
+func (m *HandshakeMessage) SetExtension() {
+	m.Reserved[5] |= 1 << 4
+}
+
+func (m *HandshakeMessage) IsExtension() bool {
+	return m.Reserved[5]&(1<<4) != 0
+}
+
+func MarshalHandshakeMessage(w io.Writer, m *HandshakeMessage) error {
+	if _, err := w.Write([]byte{byte(len(m.Protocol))}); err != nil {
+		return fmt.Errorf("write protocol length: %w", err)
+	}
+
+	if _, err := w.Write([]byte(m.Protocol)); err != nil {
+		return fmt.Errorf("write protocol: %w", err)
+	}
+
+	if _, err := w.Write(m.Reserved[:]); err != nil {
+		return fmt.Errorf("write reserved: %w", err)
+	}
+
+	if _, err := w.Write(m.InfoHash); err != nil {
+		return fmt.Errorf("write info hash: %w", err)
+	}
+
+	if _, err := w.Write(m.PeerID); err != nil {
+		return fmt.Errorf("write peer id: %w", err)
+	}
+
+	return nil
+}
+
+func UnmarshalHandshakeMessage(r io.Reader, m *HandshakeMessage) error {
+	protocolLengthBytes := make([]byte, 1)
+	if _, err := io.ReadFull(r, protocolLengthBytes); err != nil {
+		return fmt.Errorf("read protocol length: %w", err)
+	}
+
+	protocol := make([]byte, protocolLengthBytes[0])
+	if _, err := io.ReadFull(r, protocol); err != nil {
+		return fmt.Errorf("read protocol: %w", err)
+	}
+	m.Protocol = string(protocol)
+
+	if _, err := io.ReadFull(r, m.Reserved[:]); err != nil {
+		return fmt.Errorf("read reserved: %w", err)
+	}
+
+	m.InfoHash = make([]byte, 20)
+	if _, err := io.ReadFull(r, m.InfoHash); err != nil {
+		return fmt.Errorf("read info hash: %w", err)
+	}
+
+	m.PeerID = make([]byte, 20)
+	if _, err := io.ReadFull(r, m.PeerID); err != nil {
+		return fmt.Errorf("read peer id: %w", err)
+	}
+
+	return nil
+}
+
+type PeerMessage struct {
+	ID      byte
+	Payload []byte
+}
+
+const (
+	IDChoke byte = iota
+	IDUnchoke
+	IDInterested
+	IDNotInterested
+	IDHave
+	IDBitfield
+	IDRequest
+	IDPiece
+	IDCancel
+	IDExtension   byte = 20
+	IDHashRequest byte = 21
+	IDHashes      byte = 22
+	IDHashReject  byte = 23
+	IDKeepAlive   byte = 99
+)
+
+// MaxBlockLength is the largest block a piece message may carry. Blocks are
+// requested 16KB at a time, but some clients ask for more.
+const MaxBlockLength = 128 * 1024 // 128KB
+
+// maxMessageLength bounds the length of the messages other than piece,
+// which leaves room for the bitfield of millions of pieces and for
+// extension messages.
+const maxMessageLength = 1024 * 1024 // 1MB
+
+// UnmarshalPeerMessage reads a message from r. The length is announced by
+// the peer, so messages longer than their type allows are rejected before
+// the payload is allocated.
+func UnmarshalPeerMessage(r io.Reader, m *PeerMessage) error {
+	lengthBytes := make([]byte, 4)
+	if _, err := io.ReadFull(r, lengthBytes); err != nil {
+		return fmt.Errorf("read length: %w", err)
+	}
+	length := binary.BigEndian.Uint32(lengthBytes)
+
+	if length == 0 {
+		m.ID = IDKeepAlive
+		return nil
+	}
+
+	idBytes := make([]byte, 1)
+	if _, err := io.ReadFull(r, idBytes); err != nil {
+		return fmt.Errorf("read id: %w", err)
+	}
+	m.ID = idBytes[0]
+
+	// id, then index and begin ahead of the block
+	limit := uint32(maxMessageLength)
+	if m.ID == IDPiece {
+		limit = 1 + 8 + MaxBlockLength
+	}
+	if length > limit {
+		return fmt.Errorf("message %d too long: %d bytes", m.ID, length)
+	}
+
+	m.Payload = make([]byte, length-1)
+	if _, err := io.ReadFull(r, m.Payload); err != nil {
+		return fmt.Errorf("read payload: %w", err)
+	}
+
+	return nil
+}
+
+func MarshalPeerMessage(w io.Writer, m *PeerMessage) error {
+	lengthBytes := make([]byte, 4)
+	length := 0
+	if m.ID != IDKeepAlive {
+		length = 1 + len(m.Payload)
+	}
+	binary.BigEndian.PutUint32(lengthBytes, uint32(length))
+	if _, err := w.Write(lengthBytes); err != nil {
+		return fmt.Errorf("write length: %w", err)
+	}
+
+	if m.ID == IDKeepAlive {
+		return nil
+	}
+
+	if _, err := w.Write([]byte{m.ID}); err != nil {
+		return fmt.Errorf("write id: %w", err)
+	}
+
+	if _, err := w.Write(m.Payload); err != nil {
+		return fmt.Errorf("write payload: %w", err)
+	}
+
+	return nil
+}
+
+type RequestPayload struct {
+	Index  uint32
+	Begin  uint32
+	Length uint32
+}
+
+func (p *RequestPayload) MarshalBinary() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, binary.BigEndian, p.Index); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(buf, binary.BigEndian, p.Begin); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(buf, binary.BigEndian, p.Length); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type PiecePayload struct {
+	Index uint32
+	Begin uint32
+	Block []byte
+}
+
+func (p *PiecePayload) UnmarshalBinary(data []byte) error {
+	buf := bytes.NewBuffer(data)
+	if err := binary.Read(buf, binary.BigEndian, &p.Index); err != nil {
+		return err
+	}
+	if err := binary.Read(buf, binary.BigEndian, &p.Begin); err != nil {
+		return err
+	}
+	p.Block = buf.Bytes()
+	return nil
+}
+
+// https://www.bittorrent.org/beps/bep_0009.html
+type ExtensionPayload struct {
+	MessageID byte
+	Message   any
+}
+
+func (p *ExtensionPayload) UnmarshalBinary(data []byte) error {
+	buf := bytes.NewBuffer(data)
+
+	messageID, err := buf.ReadByte()
+	if err != nil {
+		return fmt.Errorf("read message id: %w", err)
+	}
+	p.MessageID = messageID
+
+	if p.Message, err = bencode.Decode(buf); err != nil {
+		return fmt.Errorf("decode message: %w", err)
+	}
+
+	return nil
+}
+
+func (p *ExtensionPayload) MarshalBinary() ([]byte, error) {
+	buf := new(bytes.Buffer)
+
+	buf.WriteByte(p.MessageID)
+
+	if err := bencode.Marshal(buf, p.Message); err != nil {
+		return nil, fmt.Errorf("marshal message: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (p *RequestPayload) UnmarshalBinary(data []byte) error {
+	if len(data) != 12 {
+		return fmt.Errorf("invalid request length %d", len(data))
+	}
+	p.Index = binary.BigEndian.Uint32(data[0:4])
+	p.Begin = binary.BigEndian.Uint32(data[4:8])
+	p.Length = binary.BigEndian.Uint32(data[8:12])
+	return nil
+}
+
+func (p *PiecePayload) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 8+len(p.Block))
+	binary.BigEndian.PutUint32(buf[0:4], p.Index)
+	binary.BigEndian.PutUint32(buf[4:8], p.Begin)
+	copy(buf[8:], p.Block)
+	return buf, nil
+}
+
+// HashRequestPayload asks for count hashes of the layer baseLayer of the
+// merkle tree with the given pieces root, starting at index, together with
+// proofLayers uncle hashes towards the root. Hash reject messages carry the
+// same payload.
+// https://www.bittorrent.org/beps/bep_0052.html
+type HashRequestPayload struct {
+	PiecesRoot  []byte
+	BaseLayer   uint32
+	Index       uint32
+	Length      uint32
+	ProofLayers uint32
+}
+
+func (p *HashRequestPayload) MarshalBinary() ([]byte, error) {
+	if len(p.PiecesRoot) != 32 {
+		return nil, fmt.Errorf("invalid pieces root length %d", len(p.PiecesRoot))
+	}
+	buf := make([]byte, 48)
+	copy(buf, p.PiecesRoot)
+	binary.BigEndian.PutUint32(buf[32:36], p.BaseLayer)
+	binary.BigEndian.PutUint32(buf[36:40], p.Index)
+	binary.BigEndian.PutUint32(buf[40:44], p.Length)
+	binary.BigEndian.PutUint32(buf[44:48], p.ProofLayers)
+	return buf, nil
+}
+
+func (p *HashRequestPayload) UnmarshalBinary(data []byte) error {
+	if len(data) != 48 {
+		return fmt.Errorf("invalid hash request length %d", len(data))
+	}
+	p.PiecesRoot = data[:32]
+	p.BaseLayer = binary.BigEndian.Uint32(data[32:36])
+	p.Index = binary.BigEndian.Uint32(data[36:40])
+	p.Length = binary.BigEndian.Uint32(data[40:44])
+	p.ProofLayers = binary.BigEndian.Uint32(data[44:48])
+	return nil
+}
+
+// HashesPayload answers a hash request with the requested hashes followed
+// by the uncle hashes, from the bottom of the tree up.
+type HashesPayload struct {
+	HashRequestPayload
+	Hashes []byte
+}
+
+func (p *HashesPayload) MarshalBinary() ([]byte, error) {
+	buf, err := p.HashRequestPayload.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return append(buf, p.Hashes...), nil
+}
+
+func (p *HashesPayload) UnmarshalBinary(data []byte) error {
+	if len(data) < 48 || (len(data)-48)%32 != 0 {
+		return fmt.Errorf("invalid hashes length %d", len(data))
+	}
+	if err := p.HashRequestPayload.UnmarshalBinary(data[:48]); err != nil {
+		return err
+	}
+	p.Hashes = data[48:]
+	return nil
+}
+
+// Bitfield is the payload of a bitfield message: bit i, counted from the high
+// bit of the first byte, is set when the piece with index i is available.
+type Bitfield []byte
+
+func NewBitfield(pieceCount int) Bitfield {
+	return make(Bitfield, (pieceCount+7)/8)
+}
+
+func (b Bitfield) Has(index int) bool {
+	if index < 0 || index/8 >= len(b) {
+		return false
+	}
+	return b[index/8]&(1<<(7-index%8)) != 0
+}
+
+func (b Bitfield) Set(index int) {
+	if index < 0 || index/8 >= len(b) {
+		return
+	}
+	b[index/8] |= 1 << (7 - index%8)
+}
+
+// Count returns the number of pieces set in the bitfield.
+func (b Bitfield) Count() int {
+	n := 0
+	for _, x := range b {
+		n += bits.OnesCount8(x)
+	}
+	return n
+}
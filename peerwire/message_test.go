@@ -0,0 +1,51 @@
+package peerwire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestUnmarshalPeerMessage(t *testing.T) {
+	block := bytes.Repeat([]byte{1}, MaxBlockLength)
+	payload, err := (&PiecePayload{Index: 3, Begin: 0, Block: block}).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := MarshalPeerMessage(&buf, &PeerMessage{ID: IDPiece, Payload: payload}); err != nil {
+		t.Fatal(err)
+	}
+	var m PeerMessage
+	if err := UnmarshalPeerMessage(&buf, &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.ID != IDPiece || !bytes.Equal(m.Payload, payload) {
+		t.Fatalf("got message %d with %d bytes", m.ID, len(m.Payload))
+	}
+}
+
+func TestUnmarshalPeerMessageTooLong(t *testing.T) {
+	tests := []struct {
+		name   string
+		id     byte
+		length uint32
+	}{
+		{"piece", IDPiece, 1 + 8 + MaxBlockLength + 1},
+		{"bitfield", IDBitfield, maxMessageLength + 1},
+		{"extension", IDExtension, 1 << 31},
+	}
+	for _, test := range tests {
+		// only the header is sent: the payload must not be read, or
+		// allocated, at all
+		header := binary.BigEndian.AppendUint32(nil, test.length)
+		header = append(header, test.id)
+		var m PeerMessage
+		err := UnmarshalPeerMessage(bytes.NewReader(header), &m)
+		if err == nil || !strings.Contains(err.Error(), "too long") {
+			t.Errorf("%s message of %d bytes: got error %v, want it rejected as too long", test.name, test.length, err)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package peerwire
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
+)
+
+// https://www.bittorrent.org/beps/bep_0009.html
+
+// ut_metadata message types.
+const (
+	MetadataRequest = 0
+	MetadataData    = 1
+	MetadataReject  = 2
+)
+
+// MetadataPieceSize is the size of every metadata piece but the last.
+const MetadataPieceSize = 16 * 1024 // 16KB
+
+// ParseMetadataMessage splits a ut_metadata message into its bencoded
+// header and, for data messages, the metadata bytes that follow it.
+func ParseMetadataMessage(payload []byte) (msgType, piece int, data []byte, err error) {
+	end, err := bencode.ValueEnd(payload, 0)
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("unmarshal metadata message: %w", err)
+	}
+	decoded, err := bencode.Decode(bytes.NewReader(payload[:end]))
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("unmarshal metadata message: %w", err)
+	}
+
+	message, ok := decoded.(map[string]any)
+	if !ok {
+		return 0, 0, nil, fmt.Errorf("metadata message is not a dictionary")
+	}
+	t, ok1 := message["msg_type"].(int64)
+	p, ok2 := message["piece"].(int64)
+	if !ok1 || !ok2 {
+		return 0, 0, nil, fmt.Errorf("invalid metadata message")
+	}
+	return int(t), int(p), payload[end:], nil
+}
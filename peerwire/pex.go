@@ -0,0 +1,100 @@
+package peerwire
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
+)
+
+// https://www.bittorrent.org/beps/bep_0011.html
+
+// PEXOutgoing is the added.f flag for peers the sender connected to itself,
+// which are known to accept connections.
+const PEXOutgoing = 0x10
+
+// PEXMessage is a ut_pex message: the peers the sender connected to and
+// disconnected from since its previous message, IPv4 and IPv6 alike.
+type PEXMessage struct {
+	Added      []string
+	AddedFlags []byte
+	Dropped    []string
+}
+
+func (m *PEXMessage) UnmarshalBinary(data []byte) error {
+	decoded, err := bencode.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("decode pex: %w", err)
+	}
+	dict, ok := decoded.(map[string]any)
+	if !ok {
+		return fmt.Errorf("pex message is not a dictionary")
+	}
+
+	added, _ := dict["added"].(string)
+	added6, _ := dict["added6"].(string)
+	flags, _ := dict["added.f"].(string)
+	flags6, _ := dict["added6.f"].(string)
+	dropped, _ := dict["dropped"].(string)
+	dropped6, _ := dict["dropped6"].(string)
+
+	m.Added = append(CompactPeers(added, net.IPv4len), CompactPeers(added6, net.IPv6len)...)
+	m.AddedFlags = pexFlags(flags, len(added)/(net.IPv4len+2))
+	m.AddedFlags = append(m.AddedFlags, pexFlags(flags6, len(added6)/(net.IPv6len+2))...)
+	m.Dropped = append(CompactPeers(dropped, net.IPv4len), CompactPeers(dropped6, net.IPv6len)...)
+	return nil
+}
+
+// pexFlags returns a flag byte for each of n added peers. Flags are
+// optional, missing ones are zero.
+func pexFlags(flags string, n int) []byte {
+	f := make([]byte, n)
+	copy(f, flags)
+	return f
+}
+
+func (m *PEXMessage) MarshalBinary() ([]byte, error) {
+	added, addedFlags, added6, addedFlags6 := "", "", "", ""
+	for i, addr := range m.Added {
+		compact, ipv6, ok := CompactAddr(addr)
+		if !ok {
+			continue
+		}
+		// flags are optional, missing ones are zero
+		flag := "\x00"
+		if i < len(m.AddedFlags) {
+			flag = string(m.AddedFlags[i : i+1])
+		}
+		if ipv6 {
+			added6, addedFlags6 = added6+compact, addedFlags6+flag
+		} else {
+			added, addedFlags = added+compact, addedFlags+flag
+		}
+	}
+	dropped, dropped6 := "", ""
+	for _, addr := range m.Dropped {
+		compact, ipv6, ok := CompactAddr(addr)
+		if !ok {
+			continue
+		}
+		if ipv6 {
+			dropped6 += compact
+		} else {
+			dropped += compact
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := bencode.Marshal(&buf, map[string]any{
+		"added":    added,
+		"added.f":  addedFlags,
+		"dropped":  dropped,
+		"added6":   added6,
+		"added6.f": addedFlags6,
+		"dropped6": dropped6,
+	}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
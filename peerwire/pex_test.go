@@ -0,0 +1,32 @@
+package peerwire
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+)
+
+func TestPEXMessageWithoutFlags(t *testing.T) {
+	sent := &PEXMessage{
+		Added:   []string{"10.0.0.1:6881", "[::1]:6882", "10.0.0.2:6883"},
+		Dropped: []string{"10.0.0.3:6884"},
+	}
+	data, err := sent.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got PEXMessage
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"10.0.0.1:6881", "10.0.0.2:6883", "[::1]:6882"}; !slices.Equal(got.Added, want) {
+		t.Errorf("got added %q, want %q", got.Added, want)
+	}
+	if !bytes.Equal(got.AddedFlags, make([]byte, 3)) {
+		t.Errorf("got flags %v, want zeros", got.AddedFlags)
+	}
+	if !slices.Equal(got.Dropped, sent.Dropped) {
+		t.Errorf("got dropped %q, want %q", got.Dropped, sent.Dropped)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package storage
+
+import (
+	"fmt"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/metainfo"
+)
+
+func NewMmap(target string, torrentInfo *metainfo.TorrentInfo) (Storage, error) {
+	return nil, fmt.Errorf("mmap storage is not supported on this platform")
+}
@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
+	"github.com/codecrafters-io/bittorrent-starter-go/metainfo"
+	"github.com/codecrafters-io/bittorrent-starter-go/peerwire"
+)
+
+// resumeFile is the bencoded content of the sidecar state file.
+type resumeFile struct {
+	InfoHash string `bencode:"info hash"`
+	Bitfield string `bencode:"bitfield"`
+}
+
+// resumeState tracks the verified pieces of a download and persists them to
+// a sidecar file next to the target, so an interrupted download can pick up
+// where it left off.
+type resumeState struct {
+	path     string
+	infoHash []byte
+
+	mu       sync.Mutex
+	bitfield peerwire.Bitfield
+}
+
+func resumeStatePath(target string) string {
+	return strings.TrimSuffix(target, string(filepath.Separator)) + ".resume"
+}
+
+// loadResumeState reads the sidecar file of target. The returned state is
+// empty when the file is missing or belongs to another torrent; loaded
+// reports whether the file was used.
+func loadResumeState(target string, torrentInfo *metainfo.TorrentInfo) (state *resumeState, loaded bool) {
+	state = &resumeState{
+		path:     resumeStatePath(target),
+		infoHash: torrentInfo.Hash(),
+		bitfield: peerwire.NewBitfield(torrentInfo.PieceCount()),
+	}
+
+	data, err := os.ReadFile(state.path)
+	if err != nil {
+		return state, false
+	}
+
+	var file resumeFile
+	if err := bencode.Unmarshal(bytes.NewReader(data), &file); err != nil {
+		return state, false
+	}
+	if file.InfoHash != string(state.infoHash) || len(file.Bitfield) != len(state.bitfield) {
+		return state, false
+	}
+
+	copy(state.bitfield, file.Bitfield)
+	return state, true
+}
+
+func (s *resumeState) Has(index int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.bitfield.Has(index)
+}
+
+// Bitfield returns a copy of the verified pieces.
+func (s *resumeState) Bitfield() peerwire.Bitfield {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append(peerwire.Bitfield(nil), s.bitfield...)
+}
+
+func (s *resumeState) Count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.bitfield.Count()
+}
+
+// MarkComplete records piece index as verified and saves the state file.
+func (s *resumeState) MarkComplete(index int) error {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.bitfield.Set(index)
+	return s.save()
+}
+
+// Save writes the state file.
+func (s *resumeState) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.save()
+}
+
+// save must be called with s.mu held. The file is replaced atomically so a
+// crash never leaves a truncated state behind.
+func (s *resumeState) save() error {
+	buf := new(bytes.Buffer)
+	err := bencode.Marshal(buf, resumeFile{
+		InfoHash: string(s.infoHash),
+		Bitfield: string(s.bitfield),
+	})
+	if err != nil {
+		return fmt.Errorf("marshal resume state: %w", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("write resume state: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("write resume state: %w", err)
+	}
+	return nil
+}
+
+func (s *resumeState) Remove() error {
+	return os.Remove(s.path)
+}
+
+// resumeDownload hash-checks the content of target already in storage, left
+// by an earlier run or present from the start. When a state file exists only
+// the pieces it lists are checked.
+func resumeDownload(target string, torrentInfo *metainfo.TorrentInfo, storage Storage) (*resumeState, error) {
+	state, loaded := loadResumeState(target, torrentInfo)
+	claimed := state.bitfield
+	state.bitfield = peerwire.NewBitfield(torrentInfo.PieceCount())
+
+	buf := make([]byte, torrentInfo.PieceLength)
+	for i := 0; i < torrentInfo.PieceCount(); i++ {
+		if loaded && !claimed.Has(i) {
+			continue
+		}
+
+		piece := buf[:torrentInfo.PieceSize(i)]
+		if err := storage.ReadPiece(i, piece); err != nil {
+			continue
+		}
+		if torrentInfo.VerifyPiece(i, piece) {
+			state.bitfield.Set(i)
+		}
+	}
+
+	if err := state.Save(); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// CheckPieces verifies every piece readable from r and reports which of
+// them are intact.
+func CheckPieces(r io.ReaderAt, torrentInfo *metainfo.TorrentInfo) peerwire.Bitfield {
+	bitfield := peerwire.NewBitfield(torrentInfo.PieceCount())
+	buf := make([]byte, torrentInfo.PieceLength)
+	for i := 0; i < torrentInfo.PieceCount(); i++ {
+		piece := buf[:torrentInfo.PieceSize(i)]
+		if _, err := r.ReadAt(piece, int64(i*torrentInfo.PieceLength)); err != nil {
+			continue
+		}
+		if torrentInfo.VerifyPiece(i, piece) {
+			bitfield.Set(i)
+		}
+	}
+	return bitfield
+}
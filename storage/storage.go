@@ -0,0 +1,267 @@
+// Package storage keeps the content of torrents while they are downloaded
+// and seeded: in the files below a download directory, memory mapped or in
+// memory.
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/metainfo"
+	"github.com/codecrafters-io/bittorrent-starter-go/peerwire"
+)
+
+// Storage holds the content of a torrent while it is downloaded. Pieces are
+// written once they are verified and may be read back at any time. All
+// methods are safe for concurrent use.
+type Storage interface {
+	// ReadPiece fills p, which is as long as the piece, with piece index.
+	ReadPiece(index int, p []byte) error
+	// WritePiece stores the data of piece index.
+	WritePiece(index int, data []byte) error
+	// MarkComplete records that piece index was verified and written.
+	MarkComplete(index int) error
+	// Completed returns the pieces that are already complete.
+	Completed() peerwire.Bitfield
+	Close() error
+}
+
+func pieceOffset(torrentInfo *metainfo.TorrentInfo, index int) int64 {
+	return int64(index) * int64(torrentInfo.PieceLength)
+}
+
+// openContentFiles creates the files of torrentInfo below target, or opens
+// them when they exist, and sizes them to their final length; new space is
+// left sparse. Padding entries have no file.
+func openContentFiles(target string, torrentInfo *metainfo.TorrentInfo, entries []metainfo.FileEntry) ([]*os.File, error) {
+	files := make([]*os.File, len(entries))
+	closeAll := func() {
+		for _, file := range files {
+			if file != nil {
+				file.Close()
+			}
+		}
+	}
+
+	for i, entry := range entries {
+		if entry.Padding {
+			continue
+		}
+
+		path, err := metainfo.ContentPath(target, torrentInfo, entry)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			closeAll()
+			return nil, fmt.Errorf("create directory: %w", err)
+		}
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("create file: %w", err)
+		}
+		files[i] = file
+		if err := file.Truncate(int64(entry.Length)); err != nil {
+			closeAll()
+			return nil, fmt.Errorf("allocate %s: %w", path, err)
+		}
+	}
+	return files, nil
+}
+
+// fileStorage keeps the content of a download in its final place, the files
+// below target, and tracks progress in a resume state file next to it.
+type fileStorage struct {
+	torrentInfo *metainfo.TorrentInfo
+	entries     []metainfo.FileEntry
+	files       []*os.File
+	state       *resumeState
+}
+
+// NewFile opens the files of torrentInfo below target, creating them as
+// needed, and checks which pieces they already hold. Progress is kept in a
+// resume state file next to target, which is removed once the download is
+// complete.
+func NewFile(target string, torrentInfo *metainfo.TorrentInfo) (Storage, error) {
+	return newFileStorage(target, torrentInfo)
+}
+
+func newFileStorage(target string, torrentInfo *metainfo.TorrentInfo) (*fileStorage, error) {
+	s := &fileStorage{torrentInfo: torrentInfo, entries: torrentInfo.FileEntries()}
+	files, err := openContentFiles(target, torrentInfo, s.entries)
+	if err != nil {
+		return nil, err
+	}
+	s.files = files
+
+	if s.state, err = resumeDownload(target, torrentInfo, s); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("resume: %w", err)
+	}
+	return s, nil
+}
+
+func (s *fileStorage) ReadPiece(index int, p []byte) error {
+	_, err := metainfo.ForEachFile(s.entries, p, pieceOffset(s.torrentInfo, index), func(i int, chunk []byte, fileOff int64) (int, error) {
+		if s.files[i] == nil {
+			clear(chunk)
+			return len(chunk), nil
+		}
+		return s.files[i].ReadAt(chunk, fileOff)
+	})
+	return err
+}
+
+func (s *fileStorage) WritePiece(index int, data []byte) error {
+	_, err := metainfo.ForEachFile(s.entries, data, pieceOffset(s.torrentInfo, index), func(i int, chunk []byte, fileOff int64) (int, error) {
+		if s.files[i] == nil {
+			return len(chunk), nil
+		}
+		return s.files[i].WriteAt(chunk, fileOff)
+	})
+	return err
+}
+
+func (s *fileStorage) MarkComplete(index int) error {
+	return s.state.MarkComplete(index)
+}
+
+func (s *fileStorage) Completed() peerwire.Bitfield {
+	return s.state.Bitfield()
+}
+
+// Close closes the files. The resume state is removed once every piece is
+// complete.
+func (s *fileStorage) Close() error {
+	var errs []error
+	for i, file := range s.files {
+		if file != nil {
+			errs = append(errs, file.Close())
+			s.files[i] = nil
+		}
+	}
+	if s.state != nil && s.state.Count() == s.torrentInfo.PieceCount() {
+		if err := s.state.Remove(); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Memory keeps the content in memory, for small torrents and tests.
+type Memory struct {
+	torrentInfo *metainfo.TorrentInfo
+
+	mu        sync.Mutex
+	data      []byte
+	completed peerwire.Bitfield
+}
+
+func NewMemory(torrentInfo *metainfo.TorrentInfo) *Memory {
+	return &Memory{
+		torrentInfo: torrentInfo,
+		data:        make([]byte, torrentInfo.TotalLength()),
+		completed:   peerwire.NewBitfield(torrentInfo.PieceCount()),
+	}
+}
+
+func (s *Memory) ReadPiece(index int, p []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	offset := pieceOffset(s.torrentInfo, index)
+	if offset+int64(len(p)) > int64(len(s.data)) {
+		return fmt.Errorf("read past end of content")
+	}
+	copy(p, s.data[offset:])
+	return nil
+}
+
+func (s *Memory) WritePiece(index int, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	offset := pieceOffset(s.torrentInfo, index)
+	if offset+int64(len(data)) > int64(len(s.data)) {
+		return fmt.Errorf("write past end of content")
+	}
+	copy(s.data[offset:], data)
+	return nil
+}
+
+func (s *Memory) MarkComplete(index int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.completed.Set(index)
+	return nil
+}
+
+func (s *Memory) Completed() peerwire.Bitfield {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append(peerwire.Bitfield(nil), s.completed...)
+}
+
+// Bytes returns the content, padding included.
+func (s *Memory) Bytes() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]byte(nil), s.data...)
+}
+
+func (s *Memory) Close() error {
+	return nil
+}
+
+// pieceFile stores a single piece in a file of its own.
+type pieceFile struct {
+	file  *os.File
+	index int
+	count int
+}
+
+// NewPieceFile creates the file at path to store piece index of
+// torrentInfo. Other pieces can't be stored.
+func NewPieceFile(path string, torrentInfo *metainfo.TorrentInfo, index int) (Storage, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create piece file: %w", err)
+	}
+	return &pieceFile{file: file, index: index, count: torrentInfo.PieceCount()}, nil
+}
+
+func (f *pieceFile) ReadPiece(index int, p []byte) error {
+	if index != f.index {
+		return fmt.Errorf("piece %d not stored", index)
+	}
+	_, err := f.file.ReadAt(p, 0)
+	return err
+}
+
+func (f *pieceFile) WritePiece(index int, data []byte) error {
+	if index != f.index {
+		return fmt.Errorf("piece %d not stored", index)
+	}
+	_, err := f.file.WriteAt(data, 0)
+	return err
+}
+
+func (f *pieceFile) MarkComplete(int) error {
+	return nil
+}
+
+func (f *pieceFile) Completed() peerwire.Bitfield {
+	return peerwire.NewBitfield(f.count)
+}
+
+func (f *pieceFile) Close() error {
+	return f.file.Close()
+}
@@ -0,0 +1,238 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Announce events. A regular re-announce has no event.
+const (
+	EventStarted   = "started"
+	EventCompleted = "completed"
+	EventStopped   = "stopped"
+)
+
+// AnnounceRequest is what we report to a tracker about a torrent. PeerID
+// and Port identify us; the counters are in bytes.
+type AnnounceRequest struct {
+	InfoHash   []byte
+	PeerID     []byte
+	Port       int
+	Uploaded   int
+	Downloaded int
+	Left       int
+	Event      string
+	TrackerID  string
+}
+
+// Announce timing. The interval a tracker asks for takes precedence over
+// defaultAnnounceInterval; failed announces are retried sooner.
+var (
+	defaultAnnounceInterval = 30 * time.Minute
+	announceRetryInterval   = time.Minute
+	announceStopTimeout     = 5 * time.Second
+)
+
+// Stats counts the bytes of a torrent for tracker announces. The
+// methods are safe on a nil receiver, which counts nothing.
+type Stats struct {
+	uploaded   atomic.Int64
+	downloaded atomic.Int64
+	left       atomic.Int64
+}
+
+func (s *Stats) AddUploaded(n int) {
+	if s != nil {
+		s.uploaded.Add(int64(n))
+	}
+}
+
+func (s *Stats) AddDownloaded(n int) {
+	if s != nil {
+		s.downloaded.Add(int64(n))
+	}
+}
+
+func (s *Stats) SetLeft(n int) {
+	if s != nil {
+		s.left.Store(int64(n))
+	}
+}
+
+func (s *Stats) AddLeft(n int) {
+	if s != nil {
+		s.left.Add(int64(n))
+	}
+}
+
+func (s *Stats) Uploaded() int {
+	if s == nil {
+		return 0
+	}
+	return int(s.uploaded.Load())
+}
+
+func (s *Stats) Downloaded() int {
+	if s == nil {
+		return 0
+	}
+	return int(s.downloaded.Load())
+}
+
+func (s *Stats) Left() int {
+	if s == nil {
+		return 0
+	}
+	return int(s.left.Load())
+}
+
+// Announcer keeps the trackers of a torrent up to date for as long as we
+// take part in the swarm: started when we join, regular re-announces with
+// the current counters, completed when the download finishes and stopped
+// when we leave. Peers learned from re-announces are delivered on Peers.
+type Announcer struct {
+	trackers *Tiers
+	request  AnnounceRequest
+	stats    *Stats
+
+	peers         chan []string
+	completed     chan struct{}
+	completedOnce sync.Once
+	stop          chan struct{}
+	stopOnce      sync.Once
+	done          chan struct{}
+}
+
+// NewAnnouncer returns an announcer for the torrent and peer identified by
+// request. The counters are taken from stats on every announce.
+func NewAnnouncer(trackers *Tiers, request AnnounceRequest, stats *Stats) *Announcer {
+	return &Announcer{
+		trackers:  trackers,
+		request:   request,
+		stats:     stats,
+		peers:     make(chan []string, 1),
+		completed: make(chan struct{}),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// Start sends the started event and returns the peers the trackers know
+// about. Re-announces then run in the background until Stop. Cancelling
+// ctx only aborts the started event.
+func (a *Announcer) Start(ctx context.Context) ([]string, error) {
+	if len(a.trackers.tiers) == 0 {
+		close(a.done)
+		return nil, fmt.Errorf("no trackers")
+	}
+
+	peers, interval, err := a.announce(ctx, EventStarted)
+	if err != nil {
+		interval = announceRetryInterval
+	}
+	go a.run(interval)
+	return peers, err
+}
+
+// Peers delivers the peers returned by re-announces.
+func (a *Announcer) Peers() <-chan []string {
+	return a.peers
+}
+
+// Completed sends the completed event. It must only be called when the
+// download finished while we were announcing, not for torrents we started
+// out with.
+func (a *Announcer) Completed() {
+	a.completedOnce.Do(func() { close(a.completed) })
+}
+
+// Stop sends the stopped event and ends the re-announces. It gives up
+// waiting for unresponsive trackers after announceStopTimeout.
+func (a *Announcer) Stop() {
+	a.stopOnce.Do(func() { close(a.stop) })
+
+	select {
+	case <-a.done:
+	case <-time.After(announceStopTimeout):
+	}
+}
+
+func (a *Announcer) run(interval time.Duration) {
+	defer close(a.done)
+
+	completed := a.completed
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		event := ""
+		select {
+		case <-timer.C:
+		case <-completed:
+			completed = nil
+			event = EventCompleted
+		case <-a.stop:
+			// a download that just finished still reports completion
+			select {
+			case <-completed:
+				a.announceLogged(EventCompleted)
+			default:
+			}
+			a.announceLogged(EventStopped)
+			return
+		}
+
+		peers, next, err := a.announceLogged(event)
+		if err != nil {
+			next = announceRetryInterval
+		}
+		if len(peers) > 0 {
+			// a slow consumer only misses peers it gets again next time
+			select {
+			case a.peers <- peers:
+			default:
+			}
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(next)
+	}
+}
+
+// announceLogged announces event in the background, where nobody but the
+// logger of the trackers sees a failure.
+func (a *Announcer) announceLogged(event string) ([]string, time.Duration, error) {
+	peers, interval, err := a.announce(context.Background(), event)
+	if err != nil {
+		a.trackers.logf("announce %s: %v", event, err)
+	}
+	return peers, interval, err
+}
+
+// announce sends event with the current counters and returns the peers and
+// the interval until the next announce.
+func (a *Announcer) announce(ctx context.Context, event string) ([]string, time.Duration, error) {
+	req := a.request
+	req.Event = event
+	if a.stats != nil {
+		req.Uploaded = a.stats.Uploaded()
+		req.Downloaded = a.stats.Downloaded()
+		req.Left = a.stats.Left()
+	}
+
+	peers, interval, err := a.trackers.Announce(ctx, &req)
+	if err != nil {
+		return nil, 0, err
+	}
+	if interval == 0 {
+		interval = defaultAnnounceInterval
+	}
+	return peers, interval, nil
+}
@@ -0,0 +1,57 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+func announceHTTP(ctx context.Context, trackerURL string, announce *AnnounceRequest) (*Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", trackerURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+
+	query := req.URL.Query()
+	query.Add("info_hash", string(announce.InfoHash))
+	query.Add("peer_id", string(announce.PeerID))
+	query.Add("port", strconv.Itoa(announce.Port))
+	query.Add("uploaded", strconv.Itoa(announce.Uploaded))
+	query.Add("downloaded", strconv.Itoa(announce.Downloaded))
+	query.Add("left", strconv.Itoa(announce.Left))
+	query.Add("compact", "1")
+	if announce.Event != "" {
+		query.Add("event", announce.Event)
+	}
+	if announce.TrackerID != "" {
+		query.Add("trackerid", announce.TrackerID)
+	}
+	req.URL.RawQuery = query.Encode()
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("do request: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+
+	var response Response
+	if err := response.UnmarshalBinary(body); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		return nil, fmt.Errorf("unmarshal response: %w", err)
+	}
+	if response.FailureReason != "" {
+		return nil, fmt.Errorf("tracker failure: %s", response.FailureReason)
+	}
+
+	return &response, nil
+}
@@ -0,0 +1,115 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
+)
+
+// ScrapeResult is the state of a swarm as reported by a tracker scrape.
+type ScrapeResult struct {
+	Seeders   int `json:"seeders"`
+	Leechers  int `json:"leechers"`
+	Completed int `json:"completed"`
+}
+
+// Scrape asks the tracker about the swarms of infoHashes. The results
+// are keyed by info hash; torrents the tracker doesn't know are missing.
+func Scrape(ctx context.Context, trackerURL string, infoHashes [][]byte) (map[string]ScrapeResult, error) {
+	u, err := url.Parse(trackerURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse tracker url: %w", err)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		return scrapeHTTP(ctx, u, infoHashes)
+	case "udp":
+		return scrapeUDP(ctx, u.Host, infoHashes)
+	default:
+		return nil, fmt.Errorf("unsupported tracker scheme %q", u.Scheme)
+	}
+}
+
+// scrapeURL derives the scrape URL from an announce URL by replacing the
+// "announce" at the start of its last path segment. Trackers whose announce
+// URL doesn't follow that convention don't support scraping.
+// https://www.bittorrent.org/beps/bep_0048.html
+func scrapeURL(announceURL *url.URL) (*url.URL, error) {
+	dir, last := path.Split(announceURL.Path)
+	if !strings.HasPrefix(last, "announce") {
+		return nil, fmt.Errorf("scrape not supported")
+	}
+
+	u := *announceURL
+	u.Path = dir + "scrape" + strings.TrimPrefix(last, "announce")
+	u.RawPath = ""
+	return &u, nil
+}
+
+func scrapeHTTP(ctx context.Context, announceURL *url.URL, infoHashes [][]byte) (map[string]ScrapeResult, error) {
+	u, err := scrapeURL(announceURL)
+	if err != nil {
+		return nil, err
+	}
+	query := u.Query()
+	for _, infoHash := range infoHashes {
+		query.Add("info_hash", string(infoHash))
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("create request: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("do request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	decoded, err := bencode.Decode(bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("decode response: %w", err)
+	}
+	dict, ok := decoded.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("response is not a dictionary")
+	}
+	if reason, ok := dict["failure reason"].(string); ok {
+		return nil, fmt.Errorf("tracker failure: %s", reason)
+	}
+
+	files, _ := dict["files"].(map[string]any)
+	results := map[string]ScrapeResult{}
+	for infoHash, value := range files {
+		file, ok := value.(map[string]any)
+		if !ok {
+			continue
+		}
+		complete, _ := file["complete"].(int64)
+		incomplete, _ := file["incomplete"].(int64)
+		downloaded, _ := file["downloaded"].(int64)
+		results[infoHash] = ScrapeResult{
+			Seeders:   int(complete),
+			Leechers:  int(incomplete),
+			Completed: int(downloaded),
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,274 @@
+// Package tracker announces torrents to HTTP and UDP trackers and scrapes
+// them for swarm statistics.
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/bittorrent-starter-go/bencode"
+	"github.com/codecrafters-io/bittorrent-starter-go/peerwire"
+)
+
+// Response is the reply to an announce. Peers and Peers6 hold the
+// compact IPv4 and IPv6 peer lists; trackers that ignore compact=1 send a
+// list of dictionaries instead, which ends up in PeerDicts.
+type Response struct {
+	FailureReason  string
+	WarningMessage string
+	Interval       int
+	MinInterval    int
+	TrackerID      string
+	Complete       int
+	Incomplete     int
+	Peers          string
+	Peers6         string
+	PeerDicts      []Peer
+}
+
+// Peer is a peer in the dictionary model of the peer list.
+type Peer struct {
+	PeerID string
+	IP     string
+	Port   int
+}
+
+// UnmarshalBinary decodes the bencoded response of an HTTP tracker.
+func (r *Response) UnmarshalBinary(data []byte) error {
+	decoded, err := bencode.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	dict, ok := decoded.(map[string]any)
+	if !ok {
+		return fmt.Errorf("response is not a dictionary")
+	}
+
+	*r = Response{}
+	r.FailureReason, _ = dict["failure reason"].(string)
+	r.WarningMessage, _ = dict["warning message"].(string)
+	r.TrackerID, _ = dict["tracker id"].(string)
+	interval, _ := dict["interval"].(int64)
+	minInterval, _ := dict["min interval"].(int64)
+	complete, _ := dict["complete"].(int64)
+	incomplete, _ := dict["incomplete"].(int64)
+	r.Interval, r.MinInterval = int(interval), int(minInterval)
+	r.Complete, r.Incomplete = int(complete), int(incomplete)
+
+	switch peers := dict["peers"].(type) {
+	case string:
+		r.Peers = peers
+	case []any:
+		for _, p := range peers {
+			peer, _ := p.(map[string]any)
+			ip, _ := peer["ip"].(string)
+			port, _ := peer["port"].(int64)
+			if ip == "" || port <= 0 || port > 65535 {
+				continue
+			}
+			peerID, _ := peer["peer id"].(string)
+			r.PeerDicts = append(r.PeerDicts, Peer{PeerID: peerID, IP: ip, Port: int(port)})
+		}
+	}
+	r.Peers6, _ = dict["peers6"].(string)
+	return nil
+}
+
+// PeerList returns the addresses of all peers in the response, IPv6
+// addresses in brackets.
+func (r *Response) PeerList() []string {
+	peers := peerwire.CompactPeers(r.Peers, net.IPv4len)
+	peers = append(peers, peerwire.CompactPeers(r.Peers6, net.IPv6len)...)
+	for _, peer := range r.PeerDicts {
+		peers = append(peers, net.JoinHostPort(peer.IP, strconv.Itoa(peer.Port)))
+	}
+	return peers
+}
+
+// Announce announces to the tracker and returns its response. The tracker
+// protocol is selected by the URL scheme.
+func Announce(ctx context.Context, trackerURL string, req *AnnounceRequest) (*Response, error) {
+	u, err := url.Parse(trackerURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse tracker url: %w", err)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		return announceHTTP(ctx, trackerURL, req)
+	case "udp":
+		return announceUDP(ctx, u.Host, req)
+	default:
+		return nil, fmt.Errorf("unsupported tracker scheme %q", u.Scheme)
+	}
+}
+
+// DefaultTimeout is how long a single tracker gets to answer an announce
+// before the next one is tried.
+const DefaultTimeout = 30 * time.Second
+
+// Tiers implements multitracker announces as described in BEP 12.
+// https://www.bittorrent.org/beps/bep_0012.html
+type Tiers struct {
+	// Timeout bounds the announce to each tracker. It is DefaultTimeout
+	// unless changed before the first announce; zero means no limit.
+	Timeout time.Duration
+
+	// Logger receives the warnings of trackers and the failures of the
+	// announces an Announcer makes in the background. Nothing is logged when
+	// it is nil.
+	Logger *log.Logger
+
+	mu    sync.Mutex
+	tiers [][]string
+
+	// trackerIDs holds the tracker id each tracker asked us to send back
+	trackerIDs map[string]string
+}
+
+// NewTiers copies tiers, dropping empty entries, and shuffles the
+// trackers within each tier.
+func NewTiers(tiers [][]string) *Tiers {
+	t := &Tiers{Timeout: DefaultTimeout, trackerIDs: map[string]string{}}
+	for _, tier := range tiers {
+		var urls []string
+		for _, u := range tier {
+			if u != "" {
+				urls = append(urls, u)
+			}
+		}
+		if len(urls) == 0 {
+			continue
+		}
+		rand.Shuffle(len(urls), func(i, j int) { urls[i], urls[j] = urls[j], urls[i] })
+		t.tiers = append(t.tiers, urls)
+	}
+	return t
+}
+
+// Announce announces to the tiers concurrently and, within each tier, to the
+// trackers in order until one responds. The responsive tracker is moved to
+// the front of its tier and the peers from every tier are merged. The
+// returned interval is the shortest one the responding trackers asked for,
+// or 0 if none did. Cancelling ctx stops the announces.
+func (t *Tiers) Announce(ctx context.Context, req *AnnounceRequest) ([]string, time.Duration, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.tiers) == 0 {
+		return nil, 0, fmt.Errorf("no trackers")
+	}
+
+	// a dead tier must not hold up the others, so each gets a goroutine
+	results := make([]tierResult, len(t.tiers))
+	var idsMu sync.Mutex
+	var wg sync.WaitGroup
+	for i, tier := range t.tiers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = t.announceTier(ctx, tier, req, &idsMu)
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var peers []string
+	seen := map[string]bool{}
+	var errs []error
+	responded := false
+	var interval time.Duration
+	for _, result := range results {
+		errs = append(errs, result.errs...)
+		if result.response == nil {
+			continue
+		}
+
+		responded = true
+		for _, peer := range result.response.PeerList() {
+			if !seen[peer] {
+				seen[peer] = true
+				peers = append(peers, peer)
+			}
+		}
+		if seconds := max(result.response.Interval, result.response.MinInterval); seconds > 0 {
+			if d := time.Duration(seconds) * time.Second; interval == 0 || d < interval {
+				interval = d
+			}
+		}
+	}
+
+	if !responded {
+		return nil, 0, errors.Join(errs...)
+	}
+	return peers, interval, nil
+}
+
+// tierResult is the outcome of announcing to a single tier: the response
+// of the tracker that answered, if any, and the errors of those that failed.
+type tierResult struct {
+	response *Response
+	errs     []error
+}
+
+// announceTier announces to the trackers of tier in order until one
+// responds, and moves it to the front. idsMu guards t.trackerIDs, which the
+// tiers share.
+func (t *Tiers) announceTier(ctx context.Context, tier []string, req *AnnounceRequest, idsMu *sync.Mutex) tierResult {
+	var result tierResult
+	for i, trackerURL := range tier {
+		if err := ctx.Err(); err != nil {
+			return result
+		}
+
+		trackerReq := *req
+		idsMu.Lock()
+		trackerReq.TrackerID = t.trackerIDs[trackerURL]
+		idsMu.Unlock()
+		response, err := t.announce(ctx, trackerURL, &trackerReq)
+		if err != nil {
+			result.errs = append(result.errs, fmt.Errorf("%s: %w", trackerURL, err))
+			continue
+		}
+		if response.WarningMessage != "" {
+			t.logf("tracker %s: %s", trackerURL, response.WarningMessage)
+		}
+		if response.TrackerID != "" {
+			idsMu.Lock()
+			t.trackerIDs[trackerURL] = response.TrackerID
+			idsMu.Unlock()
+		}
+
+		copy(tier[1:i+1], tier[:i])
+		tier[0] = trackerURL
+		result.response = response
+		return result
+	}
+	return result
+}
+
+func (t *Tiers) logf(format string, args ...any) {
+	if t.Logger != nil {
+		t.Logger.Printf(format, args...)
+	}
+}
+
+func (t *Tiers) announce(ctx context.Context, trackerURL string, req *AnnounceRequest) (*Response, error) {
+	if t.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.Timeout)
+		defer cancel()
+	}
+	return Announce(ctx, trackerURL, req)
+}
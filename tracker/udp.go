@@ -0,0 +1,303 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// https://www.bittorrent.org/beps/bep_0015.html
+const udpProtocolID uint64 = 0x41727101980
+
+const (
+	udpActionConnect uint32 = iota
+	udpActionAnnounce
+	udpActionScrape
+	udpActionError
+)
+
+// udpConnectionIDTTL is how long a connection id may be reused after it was
+// obtained from the tracker.
+const udpConnectionIDTTL = time.Minute
+
+// udpTrackerTimeout is the initial retransmission timeout. The n-th
+// retransmission waits udpTrackerTimeout * 2^n, up to udpTrackerMaxRetries.
+var udpTrackerTimeout = 15 * time.Second
+
+const udpTrackerMaxRetries = 8
+
+type udpConnectionID struct {
+	id       uint64
+	obtained time.Time
+}
+
+// udpConnectionIDs caches connection ids per tracker address so consecutive
+// requests within a minute skip the connect round trip.
+var udpConnectionIDs = struct {
+	sync.Mutex
+	m map[string]udpConnectionID
+}{m: map[string]udpConnectionID{}}
+
+// udpKeys holds the key announced to each tracker address. It is generated
+// once and then reused, so the tracker can tell us apart from other clients
+// behind the same address and recognize us when our address changes.
+var udpKeys = struct {
+	sync.Mutex
+	m map[string][]byte
+}{m: map[string][]byte{}}
+
+type udpTracker struct {
+	host string
+	key  []byte
+	conn net.Conn
+	stop func() bool
+}
+
+// dialUDPTracker opens a socket to the tracker at host. Cancelling ctx
+// closes the socket, which aborts the retransmissions in progress.
+func dialUDPTracker(ctx context.Context, host string) (*udpTracker, error) {
+	key, err := udpKey(host)
+	if err != nil {
+		return nil, err
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", host)
+	if err != nil {
+		return nil, fmt.Errorf("dial: %w", err)
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	return &udpTracker{host: host, key: key, conn: conn, stop: stop}, nil
+}
+
+// udpKey returns the key for the tracker at host, generating it on first
+// use.
+func udpKey(host string) ([]byte, error) {
+	udpKeys.Lock()
+	defer udpKeys.Unlock()
+
+	if key, ok := udpKeys.m[host]; ok {
+		return key, nil
+	}
+	key := make([]byte, 4)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("generate key: %w", err)
+	}
+	udpKeys.m[host] = key
+	return key, nil
+}
+
+func (t *udpTracker) Close() error {
+	t.stop()
+	return t.conn.Close()
+}
+
+func (t *udpTracker) cachedConnectionID() (uint64, bool) {
+	udpConnectionIDs.Lock()
+	defer udpConnectionIDs.Unlock()
+
+	c, ok := udpConnectionIDs.m[t.host]
+	if !ok || time.Since(c.obtained) >= udpConnectionIDTTL {
+		return 0, false
+	}
+	return c.id, true
+}
+
+func (t *udpTracker) storeConnectionID(id uint64) {
+	udpConnectionIDs.Lock()
+	defer udpConnectionIDs.Unlock()
+
+	udpConnectionIDs.m[t.host] = udpConnectionID{id: id, obtained: time.Now()}
+}
+
+func (t *udpTracker) forgetConnectionID() {
+	udpConnectionIDs.Lock()
+	defer udpConnectionIDs.Unlock()
+
+	delete(udpConnectionIDs.m, t.host)
+}
+
+// request sends action with body to the tracker, connecting first when no
+// valid connection id is cached, and retransmits on timeout. It returns the
+// response body following the action and transaction id.
+func (t *udpTracker) request(action uint32, body []byte) ([]byte, error) {
+	for n := 0; n <= udpTrackerMaxRetries; n++ {
+		connectionID, ok := t.cachedConnectionID()
+		if !ok {
+			resp, err := t.transact(udpProtocolID, udpActionConnect, nil, n)
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("connect: %w", err)
+			}
+			if len(resp) < 8 {
+				return nil, fmt.Errorf("connect: short response")
+			}
+			connectionID = binary.BigEndian.Uint64(resp)
+			t.storeConnectionID(connectionID)
+		}
+
+		resp, err := t.transact(connectionID, action, body, n)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			continue
+		}
+		if err != nil {
+			t.forgetConnectionID()
+			return nil, err
+		}
+		return resp, nil
+	}
+
+	return nil, fmt.Errorf("tracker did not respond")
+}
+
+// transact performs a single request/response exchange, ignoring datagrams
+// that don't carry our transaction id.
+func (t *udpTracker) transact(connectionID uint64, action uint32, body []byte, attempt int) ([]byte, error) {
+	transactionID := make([]byte, 4)
+	if _, err := rand.Read(transactionID); err != nil {
+		return nil, fmt.Errorf("generate transaction id: %w", err)
+	}
+
+	req := new(bytes.Buffer)
+	binary.Write(req, binary.BigEndian, connectionID)
+	binary.Write(req, binary.BigEndian, action)
+	req.Write(transactionID)
+	req.Write(body)
+
+	if err := t.conn.SetDeadline(time.Now().Add(udpTrackerTimeout << attempt)); err != nil {
+		return nil, fmt.Errorf("set deadline: %w", err)
+	}
+	if _, err := t.conn.Write(req.Bytes()); err != nil {
+		return nil, fmt.Errorf("write request: %w", err)
+	}
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := t.conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		if n < 8 || !bytes.Equal(buf[4:8], transactionID) {
+			continue
+		}
+
+		respAction := binary.BigEndian.Uint32(buf[0:4])
+		if respAction == udpActionError {
+			return nil, fmt.Errorf("tracker error: %s", buf[8:n])
+		}
+		if respAction != action {
+			return nil, fmt.Errorf("unexpected action %d", respAction)
+		}
+		return append([]byte(nil), buf[8:n]...), nil
+	}
+}
+
+// udpEvents maps announce events to their UDP tracker codes.
+var udpEvents = map[string]uint32{
+	"":             0,
+	EventCompleted: 1,
+	EventStarted:   2,
+	EventStopped:   3,
+}
+
+func (t *udpTracker) announce(req *AnnounceRequest) (*Response, error) {
+	body := new(bytes.Buffer)
+	body.Write(req.InfoHash)
+	body.Write(req.PeerID)
+	binary.Write(body, binary.BigEndian, uint64(req.Downloaded))
+	binary.Write(body, binary.BigEndian, uint64(req.Left))
+	binary.Write(body, binary.BigEndian, uint64(req.Uploaded))
+	binary.Write(body, binary.BigEndian, udpEvents[req.Event])
+	binary.Write(body, binary.BigEndian, uint32(0)) // ip: default
+	body.Write(t.key)
+	binary.Write(body, binary.BigEndian, int32(-1)) // num_want: default
+	binary.Write(body, binary.BigEndian, uint16(req.Port))
+
+	resp, err := t.request(udpActionAnnounce, body.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("announce: %w", err)
+	}
+	if len(resp) < 12 {
+		return nil, fmt.Errorf("announce: short response")
+	}
+
+	// interval, leechers and seeders precede the compact peer list, which
+	// holds IPv6 addresses when we talk to the tracker over IPv6
+	response := &Response{
+		Interval:   int(binary.BigEndian.Uint32(resp[0:4])),
+		Incomplete: int(binary.BigEndian.Uint32(resp[4:8])),
+		Complete:   int(binary.BigEndian.Uint32(resp[8:12])),
+	}
+	if addr, ok := t.conn.RemoteAddr().(*net.UDPAddr); ok && addr.IP.To4() == nil {
+		response.Peers6 = string(resp[12:])
+	} else {
+		response.Peers = string(resp[12:])
+	}
+	return response, nil
+}
+
+func announceUDP(ctx context.Context, host string, req *AnnounceRequest) (*Response, error) {
+	tracker, err := dialUDPTracker(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	defer tracker.Close()
+
+	response, err := tracker.announce(req)
+	if ctxErr := ctx.Err(); err != nil && ctxErr != nil {
+		return nil, ctxErr
+	}
+	return response, err
+}
+
+// udpMaxScrapeHashes is the most info hashes a single UDP scrape may carry.
+const udpMaxScrapeHashes = 74
+
+func (t *udpTracker) scrape(infoHashes [][]byte) (map[string]ScrapeResult, error) {
+	results := map[string]ScrapeResult{}
+	for len(infoHashes) > 0 {
+		batch := infoHashes[:min(len(infoHashes), udpMaxScrapeHashes)]
+		infoHashes = infoHashes[len(batch):]
+
+		resp, err := t.request(udpActionScrape, bytes.Join(batch, nil))
+		if err != nil {
+			return nil, fmt.Errorf("scrape: %w", err)
+		}
+		if len(resp) < len(batch)*12 {
+			return nil, fmt.Errorf("scrape: short response")
+		}
+
+		// seeders, completed and leechers for each hash in request order
+		for i, infoHash := range batch {
+			entry := resp[i*12:]
+			results[string(infoHash)] = ScrapeResult{
+				Seeders:   int(binary.BigEndian.Uint32(entry[0:4])),
+				Completed: int(binary.BigEndian.Uint32(entry[4:8])),
+				Leechers:  int(binary.BigEndian.Uint32(entry[8:12])),
+			}
+		}
+	}
+	return results, nil
+}
+
+func scrapeUDP(ctx context.Context, host string, infoHashes [][]byte) (map[string]ScrapeResult, error) {
+	tracker, err := dialUDPTracker(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	defer tracker.Close()
+
+	results, err := tracker.scrape(infoHashes)
+	if ctxErr := ctx.Err(); err != nil && ctxErr != nil {
+		return nil, ctxErr
+	}
+	return results, err
+}
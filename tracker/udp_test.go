@@ -0,0 +1,240 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeUDPTracker is a local stand-in for a BEP 15 tracker. It answers
+// connects, announces with peers and scrapes with fixed counts per hash,
+// or every request with failure when it is set.
+type fakeUDPTracker struct {
+	peers   []byte
+	failure string
+
+	conn net.PacketConn
+
+	mu sync.Mutex
+	// drop is the number of datagrams still to be ignored
+	drop      int
+	received  int
+	announces []fakeAnnounce
+}
+
+type fakeAnnounce struct {
+	infoHash []byte
+	left     uint64
+	event    uint32
+	key      uint32
+	port     uint16
+}
+
+const fakeConnectionID uint64 = 0x1234567890
+
+// start serves the tracker until the test ends.
+func (f *fakeUDPTracker) start(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	// shorten the retransmissions for the tests
+	timeout := udpTrackerTimeout
+	udpTrackerTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { udpTrackerTimeout = timeout })
+
+	f.conn = conn
+	go f.serve()
+}
+
+func (f *fakeUDPTracker) url() string {
+	return "udp://" + f.conn.LocalAddr().String()
+}
+
+func (f *fakeUDPTracker) serve() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, addr, err := f.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if resp := f.handle(buf[:n]); resp != nil {
+			f.conn.WriteTo(resp, addr)
+		}
+	}
+}
+
+func (f *fakeUDPTracker) handle(req []byte) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.received++
+	if f.drop > 0 {
+		f.drop--
+		return nil
+	}
+	if len(req) < 16 {
+		return nil
+	}
+	connectionID := binary.BigEndian.Uint64(req[0:8])
+	action := binary.BigEndian.Uint32(req[8:12])
+	transactionID := req[12:16]
+	body := req[16:]
+
+	resp := new(bytes.Buffer)
+	reply := func(action uint32) {
+		binary.Write(resp, binary.BigEndian, action)
+		resp.Write(transactionID)
+	}
+	if f.failure != "" {
+		reply(udpActionError)
+		resp.WriteString(f.failure)
+		return resp.Bytes()
+	}
+
+	switch {
+	case action == udpActionConnect && connectionID == udpProtocolID:
+		reply(udpActionConnect)
+		binary.Write(resp, binary.BigEndian, fakeConnectionID)
+	case action == udpActionAnnounce && connectionID == fakeConnectionID && len(body) >= 82:
+		f.announces = append(f.announces, fakeAnnounce{
+			infoHash: append([]byte(nil), body[0:20]...),
+			left:     binary.BigEndian.Uint64(body[48:56]),
+			event:    binary.BigEndian.Uint32(body[64:68]),
+			key:      binary.BigEndian.Uint32(body[72:76]),
+			port:     binary.BigEndian.Uint16(body[80:82]),
+		})
+		reply(udpActionAnnounce)
+		binary.Write(resp, binary.BigEndian, []uint32{1800, 2, 3})
+		resp.Write(f.peers)
+	case action == udpActionScrape && connectionID == fakeConnectionID:
+		reply(udpActionScrape)
+		for i := 0; i+20 <= len(body); i += 20 {
+			hash := uint32(body[i])
+			binary.Write(resp, binary.BigEndian, []uint32{hash, hash + 1, hash + 2})
+		}
+	default:
+		return nil
+	}
+	return resp.Bytes()
+}
+
+func TestUDPAnnounce(t *testing.T) {
+	f := &fakeUDPTracker{peers: []byte{10, 0, 0, 1, 0x1a, 0xe1, 10, 0, 0, 2, 0x1a, 0xe2}}
+	f.start(t)
+
+	infoHash := bytes.Repeat([]byte{7}, 20)
+	req := &AnnounceRequest{InfoHash: infoHash, PeerID: make([]byte, 20), Port: 6881, Left: 1000, Event: EventStarted}
+	response, err := Announce(context.Background(), f.url(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Interval != 1800 || response.Incomplete != 2 || response.Complete != 3 {
+		t.Errorf("got interval %d, incomplete %d, complete %d", response.Interval, response.Incomplete, response.Complete)
+	}
+	if got, want := strings.Join(response.PeerList(), ","), "10.0.0.1:6881,10.0.0.2:6882"; got != want {
+		t.Errorf("got peers %s, want %s", got, want)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.announces) != 1 {
+		t.Fatalf("got %d announces, want 1", len(f.announces))
+	}
+	got := f.announces[0]
+	if !bytes.Equal(got.infoHash, infoHash) || got.left != 1000 || got.event != udpEvents[EventStarted] || got.port != 6881 {
+		t.Errorf("tracker got %+v", got)
+	}
+}
+
+func TestUDPAnnounceKey(t *testing.T) {
+	f := &fakeUDPTracker{}
+	f.start(t)
+
+	req := &AnnounceRequest{InfoHash: make([]byte, 20), PeerID: make([]byte, 20)}
+	for i := 0; i < 2; i++ {
+		if _, err := Announce(context.Background(), f.url(), req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.announces) != 2 {
+		t.Fatalf("got %d announces, want 2", len(f.announces))
+	}
+	if first, second := f.announces[0].key, f.announces[1].key; first != second {
+		t.Errorf("key changed from %08x to %08x between announces", first, second)
+	}
+}
+
+func TestUDPScrape(t *testing.T) {
+	f := &fakeUDPTracker{}
+	f.start(t)
+
+	hashes := [][]byte{bytes.Repeat([]byte{1}, 20), bytes.Repeat([]byte{5}, 20)}
+	results, err := Scrape(context.Background(), f.url(), hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, hash := range hashes {
+		want := ScrapeResult{Seeders: int(hash[0]), Completed: int(hash[0]) + 1, Leechers: int(hash[0]) + 2}
+		if got := results[string(hash)]; got != want {
+			t.Errorf("hash %x: got %+v, want %+v", hash[0], got, want)
+		}
+	}
+}
+
+func TestUDPRetransmit(t *testing.T) {
+	f := &fakeUDPTracker{drop: 2}
+	f.start(t)
+
+	req := &AnnounceRequest{InfoHash: make([]byte, 20), PeerID: make([]byte, 20)}
+	if _, err := Announce(context.Background(), f.url(), req); err != nil {
+		t.Fatal(err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// two dropped connects, then a connect and the announce
+	if f.received != 4 {
+		t.Errorf("tracker received %d datagrams, want 4", f.received)
+	}
+}
+
+func TestUDPTimeout(t *testing.T) {
+	f := &fakeUDPTracker{drop: 1 << 30}
+	f.start(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	req := &AnnounceRequest{InfoHash: make([]byte, 20), PeerID: make([]byte, 20)}
+	start := time.Now()
+	_, err := Announce(ctx, f.url(), req)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("announce took %v, want it to stop at the deadline", elapsed)
+	}
+}
+
+func TestUDPError(t *testing.T) {
+	f := &fakeUDPTracker{failure: "torrent not registered"}
+	f.start(t)
+
+	req := &AnnounceRequest{InfoHash: make([]byte, 20), PeerID: make([]byte, 20)}
+	_, err := Announce(context.Background(), f.url(), req)
+	if err == nil || !strings.Contains(err.Error(), f.failure) {
+		t.Fatalf("got error %v, want %q", err, f.failure)
+	}
+}